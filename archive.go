@@ -0,0 +1,496 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// An index entry whose URL ends in one of archiveExts is a release archive
+// rather than a git repository. It must carry the archive's checksum:
+//
+//	vira -> https://example.org/vira-0.3.0.tar.gz
+//	    sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    version: 0.3.0
+//
+// lcr downloads it into the cache, verifies it, extracts it as the package
+// directory and runs lcr-build-files from there as for a clone. The
+// checksum plays the part of the commit in history and the installed
+// database, and cached archives make undo possible.
+var archiveExts = []string{".tar.gz", ".tgz", ".tar.zst", ".zip"}
+
+// archiveMarker records in an extracted package where it came from.
+const archiveMarker = ".lcr-archive"
+
+var sha256Re = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type archiveSource struct {
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+	Version string `json:"version,omitempty"`
+}
+
+// archiveName is the lower-cased path of an archive URL, without any query.
+func archiveName(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Path != "" {
+		raw = u.Path
+	}
+	return strings.ToLower(raw)
+}
+
+func isArchiveURL(raw string) bool {
+	name := archiveName(raw)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// readArchiveMarker returns the source of an extracted archive package, or
+// an error wrapping os.ErrNotExist for a git checkout.
+func readArchiveMarker(dir string) (*archiveSource, error) {
+	data, err := os.ReadFile(filepath.Join(dir, archiveMarker))
+	if err != nil {
+		return nil, err
+	}
+	src := &archiveSource{}
+	if err := json.Unmarshal(data, src); err != nil {
+		return nil, fmt.Errorf("%s: %w", archiveMarker, err)
+	}
+	return src, nil
+}
+
+func isArchivePackage(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, archiveMarker))
+	return err == nil
+}
+
+func cachedArchive(sum string) string {
+	return filepath.Join(cfg.CacheDir, "archives", sum)
+}
+
+// fetchArchive returns the path of the archive with checksum sum, from the
+// cache or downloaded from url.
+func fetchArchive(url, sum string) (string, error) {
+	if !sha256Re.MatchString(sum) {
+		return "", fmt.Errorf("%s: missing or malformed sha256", url)
+	}
+	path := cachedArchive(sum)
+	if _, err := os.Stat(path); err == nil {
+		slog.Debug("Using cached archive", "url", url, "path", path)
+		return path, nil
+	}
+	slog.Info("Downloading archive", "url", url)
+	var body io.ReadCloser
+	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		// Releases are far bigger than package lists, so this gets the
+		// package timeout rather than download_timeout.
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("download %s: %s", url, resp.Status)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(strings.TrimPrefix(url, "file://"))
+		if err != nil {
+			return "", err
+		}
+		body = f
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, sum, got)
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// extractArchive unpacks the archive at file, named after url, into dest,
+// which must not exist. A single top-level directory, as release archives
+// usually have, is stripped.
+func extractArchive(file, url, dest string) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	name := archiveName(url)
+	if strings.HasSuffix(name, ".zip") {
+		err = extractZip(file, tmp)
+	} else {
+		err = extractTar(file, strings.HasSuffix(name, ".tar.zst"), tmp)
+	}
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", url, err)
+	}
+	root := tmp
+	if entries, err := os.ReadDir(tmp); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(tmp, entries[0].Name())
+	}
+	return os.Rename(root, dest)
+}
+
+// archiveTarget checks that an archive member stays inside dir. Besides
+// absolute and .. paths it refuses paths that go through a symlink an
+// earlier member created, since a chain of links that each look harmless
+// can lead anywhere.
+func archiveTarget(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == "." {
+		return dir, nil
+	}
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("%q points outside the archive", name)
+	}
+	target := dir
+	for _, part := range strings.Split(clean, string(filepath.Separator)) {
+		target = filepath.Join(target, part)
+		info, err := os.Lstat(target)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%q goes through a symlink in the archive", name)
+		}
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// archiveLink creates a symlink member after checking it cannot reach out
+// of dir.
+func archiveLink(dir, target, link string) error {
+	rel, _ := filepath.Rel(dir, filepath.Join(filepath.Dir(target), link))
+	if filepath.IsAbs(link) || !filepath.IsLocal(rel) {
+		return fmt.Errorf("symlink %s -> %s points outside the archive", target, link)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(link, target)
+}
+
+func writeArchiveFile(target string, r io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func extractTar(file string, zstd bool, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !zstd {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTarStream(gz, dir)
+	}
+	// The standard library has no zstd decoder.
+	cmd := exec.Command("zstd", "-dc")
+	cmd.Stdin = f
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("zstd is needed for .tar.zst archives: %w", err)
+	}
+	if err := extractTarStream(out, dir); err != nil {
+		// zstd may be blocked writing output nobody will read.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	// Tar padding can follow the end of the archive; zstd must finish
+	// writing it before it exits.
+	io.Copy(io.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func extractTarStream(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target, err := archiveTarget(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeArchiveFile(target, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = archiveLink(dir, target, hdr.Linkname)
+		case tar.TypeXGlobalHeader:
+		default:
+			slog.Warn("Skipping unsupported archive member", "name", hdr.Name, "type", string(hdr.Typeflag))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(file, dir string) error {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		target, err := archiveTarget(dir, zf.Name)
+		if err != nil {
+			return err
+		}
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0755)
+		case mode&fs.ModeSymlink != 0:
+			var link []byte
+			if rc, oerr := zf.Open(); oerr != nil {
+				err = oerr
+			} else {
+				link, err = io.ReadAll(rc)
+				rc.Close()
+			}
+			if err == nil {
+				err = archiveLink(dir, target, string(link))
+			}
+		case mode.IsRegular():
+			rc, oerr := zf.Open()
+			if oerr != nil {
+				return oerr
+			}
+			err = writeArchiveFile(target, rc, mode)
+			rc.Close()
+		default:
+			slog.Warn("Skipping unsupported archive member", "name", zf.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackArchive fetches and extracts an archive as the package directory
+// dest and records its source there.
+func unpackArchive(src *archiveSource, dest string) error {
+	file, err := fetchArchive(src.URL, src.SHA256)
+	if err != nil {
+		return err
+	}
+	// Remember where the cached archive came from for rollbacks, which only
+	// know the checksum.
+	sidecar := file + ".json"
+	if data, err := os.ReadFile(sidecar); err == nil {
+		cached := &archiveSource{}
+		if json.Unmarshal(data, cached) == nil && cached.SHA256 == src.SHA256 {
+			src.URL = cached.URL
+			if src.Version == "" {
+				src.Version = cached.Version
+			}
+		}
+	}
+	if data, err := json.Marshal(src); err == nil {
+		os.WriteFile(sidecar, data, 0644)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := extractArchive(file, src.URL, dest); err != nil {
+		return err
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dest, archiveMarker), data, 0644)
+}
+
+// archiveSourceFor is the source of an index entry, which must be an
+// archive.
+func archiveSourceFor(e *indexEntry) *archiveSource {
+	return &archiveSource{URL: e.URL, SHA256: e.SHA256, Version: e.Version}
+}
+
+// installArchive is installURL for release archives.
+func (m *model) installArchive(pakiet string, src *archiveSource) error {
+	dest := packageDir(pakiet)
+	tp := m.txPackage(pakiet)
+	tp.URL = src.URL
+	if err := unpackArchive(src, dest); err != nil {
+		slog.Error("Archive install failed", "package", pakiet, "url", src.URL, "err", err)
+		return err
+	}
+	tp.NewCommit, tp.URL = src.SHA256, src.URL
+	if err := m.checkManifest(pakiet); err != nil {
+		tp.NewCommit = ""
+		os.RemoveAll(dest)
+		return err
+	}
+	if err := m.runPackageHook(pakiet, hookPreInstall); err != nil {
+		// Nothing of the package ran yet, so leave no trace of it.
+		tp.NewCommit = ""
+		os.RemoveAll(dest)
+		return err
+	}
+	err := m.runUnpack(dest)
+	if errors.Is(err, errScriptRejected) {
+		tp.NewCommit = ""
+		os.RemoveAll(dest)
+		return err
+	} else if err != nil {
+		slog.Error("Unpack failed", "package", pakiet, "err", err)
+		return err
+	}
+	if err := recordInstalled(pakiet, src.URL); err != nil {
+		slog.Warn("Could not record package as installed", "package", pakiet, "err", err)
+	}
+	if err := m.runPackageHook(pakiet, hookPostInstall); err != nil {
+		slog.Warn("post-install hook failed", "package", pakiet, "err", err)
+	}
+	slog.Info("Package installed", "package", pakiet)
+	return nil
+}
+
+// switchArchive replaces an installed archive package with src and runs
+// its unpack again. The old directory is kept until that has worked, and
+// put back if the new version is refused.
+func (m *model) switchArchive(pakiet string, src *archiveSource) error {
+	dest := packageDir(pakiet)
+	tp := m.txPackage(pakiet)
+	old, err := readArchiveMarker(dest)
+	if err != nil {
+		return err
+	}
+	tp.OldCommit, tp.NewCommit, tp.URL = old.SHA256, old.SHA256, old.URL
+	if old.SHA256 == src.SHA256 {
+		m.result = successStyle.Render("Already the latest version.")
+		slog.Info("Package already up to date", "package", pakiet)
+		return nil
+	}
+	// A leading dot keeps it apart from every valid package name.
+	backup := filepath.Join(filepath.Dir(dest), ".old-"+filepath.Base(dest))
+	os.RemoveAll(backup)
+	if err := os.Rename(dest, backup); err != nil {
+		return err
+	}
+	restore := func() {
+		os.RemoveAll(dest)
+		if err := os.Rename(backup, dest); err != nil {
+			slog.Error("Could not restore package directory", "package", pakiet, "err", err)
+		}
+		tp.NewCommit = tp.OldCommit
+	}
+	if err := unpackArchive(src, dest); err != nil {
+		restore()
+		return err
+	}
+	tp.NewCommit, tp.URL = src.SHA256, src.URL
+	if err := m.checkManifest(pakiet); err != nil {
+		restore()
+		return err
+	}
+	err = m.runUnpack(dest)
+	if errors.Is(err, errScriptRejected) {
+		restore()
+		return err
+	}
+	os.RemoveAll(backup)
+	if err != nil {
+		return err
+	}
+	if err := recordInstalled(pakiet, src.URL); err != nil {
+		slog.Warn("Could not record package update", "package", pakiet, "err", err)
+	}
+	if err := m.runPackageHook(pakiet, hookPostUpgrade); err != nil {
+		slog.Warn("post-upgrade hook failed", "package", pakiet, "err", err)
+	}
+	slog.Info("Package updated", "package", pakiet)
+	return nil
+}
+
+// updateArchive moves an archive package to the archive the index lists
+// for it now.
+func (m *model) updateArchive(pakiet string) error {
+	e, ok := m.packages[pakiet]
+	if !ok {
+		return fmt.Errorf("%s is not in the index, so there is nothing to update it to", pakiet)
+	}
+	if !isArchiveURL(e.URL) {
+		return fmt.Errorf("%s is now a git repository in the index; reinstall it", pakiet)
+	}
+	return m.switchArchive(pakiet, archiveSourceFor(e))
+}
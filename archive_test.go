@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tarMember is one entry of a test archive; a non-empty link makes it a
+// symlink.
+type tarMember struct {
+	name, link, body string
+}
+
+func writeTestTar(t *testing.T, members []tarMember) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.tar.gz")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(m.body))}
+		if m.link != "" {
+			hdr = &tar.Header{Name: m.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: m.link}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(m.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func writeTestZip(t *testing.T, members []tarMember) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, m := range members {
+		hdr := &zip.FileHeader{Name: m.name}
+		body := m.body
+		if m.link != "" {
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = m.link
+		} else {
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestExtractRefusesEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []tarMember
+	}{
+		{"dotdot", []tarMember{{name: "../pwned", body: "x"}}},
+		{"nested dotdot", []tarMember{{name: "a/../../pwned", body: "x"}}},
+		{"absolute", []tarMember{{name: "/tmp/pwned", body: "x"}}},
+		{"absolute link", []tarMember{{name: "l", link: "/etc"}}},
+		{"dotdot link", []tarMember{{name: "l", link: "../.."}}},
+		{"file through link", []tarMember{
+			{name: "x/l", link: ".."},
+			{name: "x/l/pwned", body: "x"},
+		}},
+		{"link chain", []tarMember{
+			{name: "x/l", link: ".."},
+			{name: "x/l/l2", link: ".."},
+			{name: "l2/pwned", body: "x"},
+		}},
+		{"file over link", []tarMember{
+			{name: "l", link: "target"},
+			{name: "l", body: "x"},
+		}},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"tar", "zip"} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				parent := t.TempDir()
+				dir := filepath.Join(parent, "extract")
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if format == "zip" {
+					err = extractZip(writeTestZip(t, tt.members), dir)
+				} else {
+					err = extractTar(writeTestTar(t, tt.members), false, dir)
+				}
+				if err == nil {
+					t.Fatal("extraction succeeded, want an error")
+				}
+				for _, escaped := range []string{filepath.Join(parent, "pwned"), filepath.Join(parent, "l2")} {
+					if _, err := os.Lstat(escaped); err == nil {
+						t.Errorf("%s was written outside the extraction directory", escaped)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractKeepsLocalLinks(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestTar(t, []tarMember{
+		{name: "pkg/bin/tool", body: "#!/bin/sh\n"},
+		{name: "pkg/tool", link: "bin/tool"},
+		{name: "pkg/bin/self", link: "../bin"},
+	})
+	if err := extractTar(file, false, dir); err != nil {
+		t.Fatal(err)
+	}
+	link, err := os.Readlink(filepath.Join(dir, "pkg", "tool"))
+	if err != nil || link != "bin/tool" {
+		t.Fatalf("pkg/tool links to %q, %v", link, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "pkg", "tool"))
+	if err != nil || !strings.HasPrefix(string(data), "#!") {
+		t.Fatalf("reading through pkg/tool: %q, %v", data, err)
+	}
+}
+
+// writeTestZstd recompresses a test tarball with zstd.
+func writeTestZstd(t *testing.T, members []tarMember) string {
+	t.Helper()
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	gz, err := os.Open(writeTestTar(t, members))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "test.tar.zst")
+	cmd := exec.Command("zstd", "-q", "-o", file)
+	cmd.Stdin = zr
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("zstd: %v: %s", err, out)
+	}
+	return file
+}
+
+func TestExtractZstdStopsEarly(t *testing.T) {
+	// Far more than a pipe buffer follows the bad member, so zstd is still
+	// writing when extraction gives up.
+	big := strings.Repeat("x", 4<<20)
+	file := writeTestZstd(t, []tarMember{
+		{name: "../pwned", body: "x"},
+		{name: "big1", body: big},
+		{name: "big2", body: big},
+	})
+	done := make(chan error, 1)
+	go func() { done <- extractTar(file, true, t.TempDir()) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("extraction succeeded, want an error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("extraction hung after an early error")
+	}
+}
+
+func TestExtractZstdReportsCorruptStream(t *testing.T) {
+	file := writeTestZstd(t, []tarMember{{name: "a", body: strings.Repeat("data", 1000)}})
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drop the end of the frame: the tar reader may already have all it
+	// needs, so only zstd's exit status tells that the stream is broken.
+	truncated := filepath.Join(t.TempDir(), "truncated.tar.zst")
+	if err := os.WriteFile(truncated, data[:len(data)-4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTar(truncated, true, t.TempDir()); err == nil {
+		t.Fatal("extraction of a truncated stream succeeded")
+	}
+}
+
+func TestExtractZstd(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestZstd(t, []tarMember{{name: "pkg/a", body: "hello"}})
+	if err := extractTar(file, true, dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "pkg", "a")); err != nil || string(data) != "hello" {
+		t.Fatalf("pkg/a is %q, %v", data, err)
+	}
+}
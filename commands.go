@@ -2,9 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
@@ -13,31 +16,55 @@ import (
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
+// loadPackages reads every configured source. When two sources list the
+// same name, the one configured first wins.
 func (m *model) loadPackages() error {
-	log.Println("Loading packages...")
-	path, err := downloadRepoList()
-	if err != nil {
-		return err
-	}
-	m.packages, err = parseRepoList(path)
-	if err != nil {
-		return err
+	slog.Debug("Loading packages")
+	m.packages = make(map[string]*indexEntry)
+	for _, source := range cfg.Sources {
+		path, err := downloadRepoList(source)
+		if err != nil {
+			return err
+		}
+		packages, err := parseRepoList(path)
+		if err != nil {
+			return err
+		}
+		for name, e := range packages {
+			if _, ok := m.packages[name]; !ok {
+				m.packages[name] = e
+			}
+		}
 	}
-	log.Println("Packages loaded successfully.")
+	slog.Info("Packages loaded", "count", len(m.packages))
 	return nil
 }
 
-func downloadRepoList() (string, error) {
-	log.Println("Downloading repo list...")
-	url := "https://raw.githubusercontent.com/LegendaryOS/lcr/main/library/repo-list.lcr"
-	path := "/tmp/repo-list.lcr"
-	resp, err := http.Get(url)
+// downloadRepoList fetches a package list into the cache directory. Sources
+// that are not http(s) URLs are read in place.
+func downloadRepoList(source string) (string, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return strings.TrimPrefix(source, "file://"), nil
+	}
+	slog.Info("Downloading repo list", "source", source)
+	sum := sha256.Sum256([]byte(source))
+	path := filepath.Join(cfg.CacheDir, fmt.Sprintf("repo-list-%x.lcr", sum[:4]))
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: cfg.DownloadTimeout}
+	resp, err := client.Get(source)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", source, resp.Status)
+	}
 	f, err := os.Create(path)
 	if err != nil {
 		return "", err
@@ -47,105 +74,388 @@ func downloadRepoList() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	log.Println("Repo list downloaded.")
+	slog.Debug("Repo list downloaded", "path", path)
 	return path, nil
 }
 
-func parseRepoList(path string) (map[string]string, error) {
-	log.Println("Parsing repo list...")
+// indexEntry is a package listed in repo-list.lcr. An entry is a
+// "name -> url" line, optionally followed by indented "key: value" lines:
+//
+//	vira -> https://github.com/Vira-Lang/Vira-LCR.git
+//	    description: The Vira programming language
+//	    tags: language, compiler
+type indexEntry struct {
+	Name        string
+	URL         string
+	Description string
+	Tags        []string
+
+	// SHA256 and Version describe release archives; see archiveExts.
+	SHA256  string
+	Version string
+}
+
+func parseRepoList(path string) (map[string]*indexEntry, error) {
+	slog.Debug("Parsing repo list", "path", path)
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	packages := make(map[string]string)
+	packages := make(map[string]*indexEntry)
+	// last is nil after an invalid entry so its metadata is skipped too.
+	var last *indexEntry
+	lines := make(map[string]int)
+	warn := func(n int, msg string, err error) {
+		slog.Warn(msg, "path", path, "line", n, "err", err)
+	}
 	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for n := 1; scanner.Scan(); n++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		parts := strings.SplitN(line, " -> ", 2)
-		if len(parts) == 2 {
-			packages[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		if raw[0] == ' ' || raw[0] == '\t' {
+			if last == nil {
+				continue
+			}
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				warn(n, "Ignoring repo list metadata", fmt.Errorf("expected \"key: value\""))
+				continue
+			}
+			if err := last.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				warn(n, "Ignoring repo list metadata", err)
+			}
+			continue
+		}
+		last = nil
+		name, url, ok := strings.Cut(line, " -> ")
+		if !ok {
+			warn(n, "Skipping malformed repo list line", fmt.Errorf("expected \"name -> url\""))
+			continue
+		}
+		e := &indexEntry{Name: strings.TrimSpace(name), URL: strings.TrimSpace(url)}
+		if err := validatePackageName(e.Name); err != nil {
+			warn(n, "Skipping repo list entry", err)
+			continue
 		}
+		if err := validateRepoURL(e.URL); err != nil {
+			warn(n, "Skipping repo list entry", err)
+			continue
+		}
+		if _, dup := packages[e.Name]; dup {
+			warn(n, "Skipping duplicate repo list entry", fmt.Errorf("%s is listed twice", e.Name))
+			continue
+		}
+		packages[e.Name] = e
+		lines[e.Name] = n
+		last = e
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	log.Println("Repo list parsed.")
+	for name, e := range packages {
+		if isArchiveURL(e.URL) && e.SHA256 == "" {
+			warn(lines[name], "Skipping repo list entry", fmt.Errorf("archive %s has no sha256", name))
+			delete(packages, name)
+		}
+	}
+	slog.Debug("Repo list parsed", "path", path, "count", len(packages))
 	return packages, nil
 }
 
+func (e *indexEntry) set(key, value string) error {
+	switch key {
+	case "description":
+		e.Description = value
+	case "tags":
+		e.Tags = nil
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				e.Tags = append(e.Tags, tag)
+			}
+		}
+	case "sha256":
+		value = strings.ToLower(value)
+		if !sha256Re.MatchString(value) {
+			return fmt.Errorf("sha256 for %s is not 64 hex digits", e.Name)
+		}
+		e.SHA256 = value
+	case "version":
+		e.Version = value
+	default:
+		return fmt.Errorf("unknown key %q for %s", key, e.Name)
+	}
+	return nil
+}
+
 func (m *model) install(pakiet string) error {
-	log.Printf("Installing package: %s\n", pakiet)
-	url, ok := m.packages[pakiet]
+	slog.Info("Installing package", "package", pakiet)
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	entry, ok := m.packages[pakiet]
 	if !ok {
 		err := fmt.Errorf("package %s not found", pakiet)
-		log.Println(err)
+		slog.Error("Package not found", "package", pakiet)
+		return err
+	}
+	if isArchiveURL(entry.URL) {
+		return m.installURL(pakiet, entry.URL, entry.SHA256)
+	}
+	return m.installURL(pakiet, entry.URL, "")
+}
+
+// installURL clones url as pakiet and runs its unpack.sh. A non-empty commit
+// is checked out before unpacking instead of the default branch tip. For a
+// release archive, commit is its sha256.
+func (m *model) installURL(pakiet, url, commit string) error {
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	if err := validateRepoURL(url); err != nil {
 		return err
 	}
-	dest := filepath.Join("/usr/lib/lcr", pakiet)
-	_, err := git.PlainClone(dest, false, &git.CloneOptions{URL: url})
+	if isArchiveURL(url) {
+		src := &archiveSource{URL: url, SHA256: commit}
+		if e, ok := m.packages[pakiet]; ok && e.URL == url && e.SHA256 == commit {
+			src.Version = e.Version
+		}
+		return m.installArchive(pakiet, src)
+	}
+	dest := packageDir(pakiet)
+	tp := m.txPackage(pakiet)
+	tp.URL = url
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	// An older commit may be beyond a shallow clone, so take all of history.
+	depth := cfg.CloneDepth
+	if commit != "" {
+		depth = 0
+	}
+	repo, err := git.PlainCloneContext(ctx, dest, false, &git.CloneOptions{
+		URL: url, Depth: depth, SingleBranch: true, Progress: m.gitProgress,
+	})
 	if err != nil {
-		log.Println("Clone error:", err)
+		slog.Error("Clone failed", "package", pakiet, "url", url, "err", err)
+		return err
+	}
+	if commit != "" {
+		w, err := repo.Worktree()
+		if err != nil {
+			return err
+		}
+		if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(commit), Mode: git.HardReset}); err != nil {
+			return err
+		}
+	} else if isLocalDir(url) {
+		if err := syncWorkingTree(url, dest); err != nil {
+			os.RemoveAll(dest)
+			return err
+		}
+	}
+	tp.NewCommit, _ = headCommit(dest)
+	if err := m.checkManifest(pakiet); err != nil {
+		// Nothing ran, so leave no trace of the package.
+		tp.NewCommit = ""
+		os.RemoveAll(dest)
+		return err
+	}
+	if err := m.runPackageHook(pakiet, hookPreInstall); err != nil {
+		// Nothing of the package ran yet, so leave no trace of it.
+		tp.NewCommit = ""
+		os.RemoveAll(dest)
 		return err
 	}
 	err = m.runUnpack(dest)
-	if err != nil {
-		log.Println("Unpack error:", err)
+	if errors.Is(err, errScriptRejected) {
+		tp.NewCommit = ""
+		os.RemoveAll(dest)
+		return err
+	} else if err != nil {
+		slog.Error("Unpack failed", "package", pakiet, "err", err)
 		return err
 	}
-	log.Printf("Package %s installed.\n", pakiet)
+	if err := recordInstalled(pakiet, url); err != nil {
+		slog.Warn("Could not record package as installed", "package", pakiet, "err", err)
+	}
+	if err := m.runPackageHook(pakiet, hookPostInstall); err != nil {
+		slog.Warn("post-install hook failed", "package", pakiet, "err", err)
+	}
+	slog.Info("Package installed", "package", pakiet)
 	return nil
 }
 
+// out is where package scripts write their output. The TUI redirects it into
+// its output pane; the CLI leaves it on the terminal.
+func (m *model) out() io.Writer {
+	if m.stdout != nil {
+		return m.stdout
+	}
+	return os.Stdout
+}
+
+func (m *model) errOut() io.Writer {
+	if m.stdout != nil {
+		return m.stdout
+	}
+	return os.Stderr
+}
+
+// scriptEnv is the environment unpack.sh and remove.sh run with. Scripts
+// should install below $DESTDIR$LCR_PREFIX so they work in per-user mode and
+// with --root too.
+func scriptEnv(pakiet string) []string {
+	return append(baseScriptEnv(),
+		"LCR_PACKAGE="+pakiet,
+		"LCR_PACKAGE_DIR="+insideRoot(packageDir(pakiet)),
+	)
+}
+
+// baseScriptEnv is the part of scriptEnv system hooks get too.
+func baseScriptEnv() []string {
+	mode := "system"
+	if cfg.User {
+		mode = "user"
+	}
+	env := append(os.Environ(),
+		"LCR_PREFIX="+cfg.Prefix,
+		"LCR_MODE="+mode,
+	)
+	if cfg.Root != "" {
+		env = append(env, "LCR_ROOT="+cfg.Root)
+		if !cfg.Chroot {
+			env = append(env, "DESTDIR="+cfg.Root)
+		}
+	}
+	return env
+}
+
+// scriptCommand prepares a package script to run from its build directory,
+// inside the alternate root when chroot is enabled.
+func (m *model) scriptCommand(pakiet, script string) *exec.Cmd {
+	buildDir := filepath.Dir(script)
+	var cmd *exec.Cmd
+	if cfg.Root != "" && cfg.Chroot {
+		// chroot(8) changes to / first, so change back to the build dir inside.
+		cmd = exec.Command("chroot", cfg.Root, "/bin/sh", "-c", `cd "$1" && exec /bin/sh "$2"`,
+			"sh", insideRoot(buildDir), insideRoot(script))
+	} else {
+		cmd = exec.Command("/bin/sh", script)
+		cmd.Dir = buildDir
+	}
+	cmd.Env = scriptEnv(pakiet)
+	cmd.Stdout = m.out()
+	cmd.Stderr = m.errOut()
+	return cmd
+}
+
 func (m *model) runUnpack(dest string) error {
-	log.Println("Running unpack.sh...")
-	buildDir := filepath.Join(dest, "lcr-build-files")
-	unpack := filepath.Join(buildDir, "unpack.sh")
+	if mf, err := loadManifest(dest); err != nil {
+		return err
+	} else if mf != nil && len(mf.Install) > 0 {
+		// Callers check the whole manifest, but paths are too important to
+		// rely on that.
+		if errs := mf.schemaErrors(); len(errs) > 0 {
+			return errs[0]
+		}
+		if err := m.checkInstallPlan(filepath.Base(dest), mf); err != nil {
+			return err
+		}
+		if err := m.checkRemoveScript(dest); err != nil {
+			return err
+		}
+		slog.Info("Installing files from manifest", "dir", dest)
+		return m.installFiles(filepath.Base(dest), mf)
+	}
+	unpack := packageScript(dest, "unpack")
+	slog.Info("Running unpack script", "dir", dest, "script", filepath.Base(unpack))
 	err := os.Chmod(unpack, 0755)
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command("/bin/sh", unpack)
-	cmd.Dir = buildDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	if err := m.checkScript(filepath.Base(dest), unpack); err != nil {
+		return err
+	}
+	if err := m.checkRemoveScript(dest); err != nil {
+		return err
+	}
+	err = m.runScript(filepath.Base(dest), unpack)
 	if err != nil {
 		return err
 	}
-	log.Println("unpack.sh executed.")
+	slog.Debug("unpack.sh finished", "dir", dest)
 	return nil
 }
 
+// checkRemoveScript reviews remove.sh together with the way a package
+// installs, so that it is approved before there is anything to remove.
+func (m *model) checkRemoveScript(dest string) error {
+	script := packageScript(dest, "remove")
+	if !fileExists(script) {
+		return nil
+	}
+	return m.checkScript(filepath.Base(dest), script)
+}
+
 func (m *model) remove(pakiet string) error {
-	log.Printf("Removing package: %s\n", pakiet)
-	dest := filepath.Join("/usr/lib/lcr", pakiet)
-	buildDir := filepath.Join(dest, "lcr-build-files")
-	removeSh := filepath.Join(buildDir, "remove.sh")
+	slog.Info("Removing package", "package", pakiet)
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	dest := packageDir(pakiet)
+	tp := m.txPackage(pakiet)
+	tp.OldCommit, _ = headCommit(dest)
+	tp.URL, _ = originURL(dest)
+	if err := m.runPackageHook(pakiet, hookPreRemove); err != nil {
+		tp.NewCommit = tp.OldCommit
+		return err
+	}
+	removeSh := packageScript(dest, "remove")
 	if _, err := os.Stat(removeSh); err == nil {
-		cmd := exec.Command("/bin/sh", removeSh)
-		cmd.Dir = buildDir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			log.Printf("Warning: remove.sh failed for %s: %v\n", pakiet, err)
+		if err := m.checkScript(pakiet, removeSh); err != nil {
+			tp.NewCommit = tp.OldCommit
+			return err
+		}
+		if err := m.runScript(pakiet, removeSh); err != nil {
+			slog.Warn("remove.sh failed", "package", pakiet, "err", err)
 		}
 	}
+	if err := m.removeFiles(pakiet); err != nil {
+		slog.Warn("Could not remove installed files", "package", pakiet, "err", err)
+	}
+	tp.checkoutFiles()
 	err := os.RemoveAll(dest)
 	if err != nil {
-		log.Println("Remove directory error:", err)
+		slog.Error("Could not remove package directory", "package", pakiet, "err", err)
 		return err
 	}
-	log.Printf("Package %s removed.\n", pakiet)
+	if err := forgetInstalled(pakiet); err != nil {
+		slog.Warn("Could not drop package from installed database", "package", pakiet, "err", err)
+	}
+	slog.Info("Package removed", "package", pakiet)
 	return nil
 }
 
+func (m *model) reinstall(pakiet string) error {
+	slog.Info("Reinstalling package", "package", pakiet)
+	if err := m.remove(pakiet); err != nil {
+		return err
+	}
+	return m.install(pakiet)
+}
+
 func (m *model) update(pakiet string) error {
-	log.Printf("Updating package: %s\n", pakiet)
-	dest := filepath.Join("/usr/lib/lcr", pakiet)
+	slog.Info("Updating package", "package", pakiet)
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	dest := packageDir(pakiet)
+	if isArchivePackage(dest) {
+		return m.updateArchive(pakiet)
+	}
 	repo, err := git.PlainOpen(dest)
 	if err != nil {
 		return err
@@ -154,54 +464,253 @@ func (m *model) update(pakiet string) error {
 	if err != nil {
 		return err
 	}
-	err = w.Pull(&git.PullOptions{RemoteName: "origin"})
+	tp := m.txPackage(pakiet)
+	tp.OldCommit, _ = headCommit(dest)
+	tp.NewCommit = tp.OldCommit
+	tp.URL, _ = originURL(dest)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	if isLocalDir(tp.URL) {
+		// Always unpack again: the working tree may have changed without a
+		// new commit.
+		err = m.pullLocal(repo, w, tp.URL, dest)
+	} else {
+		err = m.pullTip(ctx, repo, w)
+	}
 	if err == git.NoErrAlreadyUpToDate {
 		m.result = successStyle.Render("Already the latest version.")
-		log.Println("Already up to date.")
+		slog.Info("Package already up to date", "package", pakiet)
 		return nil
 	} else if err != nil {
-		log.Println("Pull error:", err)
+		slog.Error("Pull failed", "package", pakiet, "err", err)
+		return err
+	}
+	tp.NewCommit, _ = headCommit(dest)
+	if err := m.checkManifest(pakiet); err != nil {
+		// Go back so the next update offers the new commit again.
+		resetToOld(w, tp)
 		return err
 	}
 	err = m.runUnpack(dest)
+	if errors.Is(err, errScriptRejected) {
+		resetToOld(w, tp)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if err := recordInstalled(pakiet, ""); err != nil {
+		slog.Warn("Could not record package update", "package", pakiet, "err", err)
+	}
+	if err := m.runPackageHook(pakiet, hookPostUpgrade); err != nil {
+		slog.Warn("post-upgrade hook failed", "package", pakiet, "err", err)
+	}
+	slog.Info("Package updated", "package", pakiet)
+	return nil
+}
+
+// checkoutCommit moves an installed package back to commit and runs its
+// unpack.sh again.
+func (m *model) checkoutCommit(pakiet, commit string) error {
+	slog.Info("Checking out package commit", "package", pakiet, "commit", commit)
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	dest := packageDir(pakiet)
+	if src, err := readArchiveMarker(dest); err == nil {
+		// The archive is still in the cache if this version was installed.
+		return m.switchArchive(pakiet, &archiveSource{URL: src.URL, SHA256: commit})
+	}
+	repo, err := git.PlainOpen(dest)
+	if err != nil {
+		return err
+	}
+	if repo, err = m.fetchCommit(repo, dest, commit); err != nil {
+		return err
+	}
+	w, err := repo.Worktree()
 	if err != nil {
 		return err
 	}
-	log.Printf("Package %s updated.\n", pakiet)
+	tp := m.txPackage(pakiet)
+	tp.OldCommit, _ = headCommit(dest)
+	tp.URL, _ = originURL(dest)
+	if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(commit), Mode: git.HardReset}); err != nil {
+		return err
+	}
+	tp.NewCommit = commit
+	if err := m.checkManifest(pakiet); err != nil {
+		resetToOld(w, tp)
+		return err
+	}
+	if err := m.runUnpack(dest); errors.Is(err, errScriptRejected) {
+		resetToOld(w, tp)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if err := recordInstalled(pakiet, ""); err != nil {
+		slog.Warn("Could not record package update", "package", pakiet, "err", err)
+	}
+	if err := m.runPackageHook(pakiet, hookPostUpgrade); err != nil {
+		slog.Warn("post-upgrade hook failed", "package", pakiet, "err", err)
+	}
 	return nil
 }
 
+// fetchTip fetches the tip of the branch a package checkout follows, no
+// deeper than clone_depth, and returns it.
+func (m *model) fetchTip(ctx context.Context, repo *git.Repository) (*plumbing.Reference, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	branch := head.Name()
+	if b, err := repo.Branch(branch.Short()); err == nil && b.Merge != "" {
+		branch = b.Merge
+	}
+	tracking := plumbing.NewRemoteReferenceName("origin", branch.Short())
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec("+" + branch + ":" + tracking)},
+		Depth:      cfg.CloneDepth,
+		Force:      true,
+		Progress:   m.gitProgress,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
+	}
+	return repo.Reference(tracking, true)
+}
+
+// pullTip moves a package checkout to the tip of its branch. Unlike a pull
+// it does not check that the new tip descends from the old one, which needs
+// history a shallow clone does not have.
+func (m *model) pullTip(ctx context.Context, repo *git.Repository, w *git.Worktree) error {
+	tip, err := m.fetchTip(ctx, repo)
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if tip.Hash() == head.Hash() {
+		return git.NoErrAlreadyUpToDate
+	}
+	return w.Reset(&git.ResetOptions{Commit: tip.Hash(), Mode: git.HardReset})
+}
+
+// fetchCommit makes sure a package checkout has commit, which a shallow
+// clone may not. It asks the remote for that commit alone and, when the
+// remote cannot do that, replaces the repository with a full clone. The
+// worktree is left alone either way.
+func (m *model) fetchCommit(repo *git.Repository, dest, commit string) (*git.Repository, error) {
+	hash := plumbing.NewHash(commit)
+	if _, err := repo.CommitObject(hash); err == nil {
+		return repo, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(commit + ":refs/lcr/" + commit)},
+		Progress:   m.gitProgress,
+	})
+	if err == nil || err == git.NoErrAlreadyUpToDate {
+		if _, err := repo.CommitObject(hash); err == nil {
+			return repo, nil
+		}
+	}
+	slog.Info("Fetching full history", "dir", dest, "commit", commit, "err", err)
+	url, err := originURL(dest)
+	if err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(filepath.Dir(dest), ".full-"+filepath.Base(dest))
+	os.RemoveAll(tmp)
+	defer os.RemoveAll(tmp)
+	_, err = git.PlainCloneContext(ctx, tmp, false, &git.CloneOptions{URL: url, NoCheckout: true, Progress: m.gitProgress})
+	if err != nil {
+		return nil, err
+	}
+	gitDir := filepath.Join(dest, ".git")
+	if err := os.RemoveAll(gitDir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(filepath.Join(tmp, ".git"), gitDir); err != nil {
+		return nil, err
+	}
+	repo, err = git.PlainOpen(dest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repo.CommitObject(hash); err != nil {
+		return nil, fmt.Errorf("commit %s: %w", shortCommit(commit), err)
+	}
+	return repo, nil
+}
+
+// resetToOld moves a package back to the commit it had before a refused
+// update.
+func resetToOld(w *git.Worktree, tp *txPackage) {
+	tp.NewCommit = tp.OldCommit
+	if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(tp.OldCommit), Mode: git.HardReset}); err != nil {
+		slog.Error("Could not go back to the previous commit", "package", tp.Name, "err", err)
+	}
+}
+
 func (m *model) upgrade() error {
-	log.Println("Upgrading all packages...")
-	dir := "/usr/lib/lcr"
-	files, err := os.ReadDir(dir)
+	slog.Info("Upgrading all packages")
+	pkgs, err := listInstalled()
 	if err != nil {
 		return err
 	}
-	for _, f := range files {
-		if f.IsDir() {
-			err := m.update(f.Name())
-			if err != nil {
-				log.Printf("Failed to update %s: %v\n", f.Name(), err)
-			}
+	for _, p := range pkgs {
+		if err := m.update(p.Name); err != nil {
+			slog.Error("Failed to update package", "package", p.Name, "err", err)
 		}
 	}
-	log.Println("Upgrade complete.")
+	slog.Info("Upgrade complete")
 	return nil
 }
 
+// autoremove removes the packages that were installed as dependencies and
+// are no longer needed.
+func (m *model) autoremove() error {
+	slog.Info("Removing unneeded dependencies")
+	names, err := unneededPackages()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range names {
+		if err := m.remove(name); err != nil {
+			slog.Error("Failed to remove package", "package", name, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (m *model) find() (tea.Model, tea.Cmd) {
-	log.Printf("Searching for packages with query: %s\n", m.query)
+	slog.Debug("Searching for packages", "query", m.query)
+	packages, installed := withInstalled(m.packages)
+	results, err := searchPackages(packages, m.query, m.searchOpts, installed)
+	if err != nil {
+		m.result = errorStyle.Render(fmt.Sprintf("Error: %v", err))
+		m.state = stateResult
+		slog.Error("Search failed", "query", m.query, "err", err)
+		return m, nil
+	}
+	m.found = results
 	var items []list.Item
-	for pkg, url := range m.packages {
-		if strings.Contains(strings.ToLower(pkg), strings.ToLower(m.query)) {
-			items = append(items, item{title: pkg, desc: url})
-		}
+	for _, r := range results {
+		items = append(items, resultItem{r})
 	}
 	if len(items) == 0 {
 		m.result = infoStyle.Render("No packages found.")
 		m.state = stateResult
-		log.Println("No packages found.")
+		slog.Debug("No packages found", "query", m.query)
 		return m, nil
 	}
 	delegate := list.NewDefaultDelegate()
@@ -210,7 +719,12 @@ func (m *model) find() (tea.Model, tea.Cmd) {
 	l := list.New(items, delegate, 0, 0)
 	l.Title = "Found Packages"
 	l.Styles.Title = subtitleStyle
-	m.list = l
-	log.Println("Search results displayed.")
+	l.DisableQuitKeybindings()
+	if m.width > 0 {
+		h, v := docStyle.GetFrameSize()
+		l.SetSize(m.width-h, m.height-v-2)
+	}
+	m.results = l
+	slog.Debug("Search results displayed", "count", len(results))
 	return m, nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// useTestConfig points everything lcr writes at a temporary directory and
+// restores the configuration when the test ends.
+func useTestConfig(t *testing.T) {
+	t.Helper()
+	saved := *cfg
+	t.Cleanup(func() { *cfg = saved })
+	tmp := t.TempDir()
+	cfg.Root = ""
+	cfg.User = false
+	cfg.InstallRoot = filepath.Join(tmp, "packages")
+	cfg.StateDir = filepath.Join(tmp, "state")
+	cfg.CacheDir = filepath.Join(tmp, "cache")
+	cfg.Prefix = filepath.Join(tmp, "prefix")
+	cfg.HooksDir = filepath.Join(tmp, "hooks")
+	cfg.AllowFileURLs = true
+	cfg.ScriptPolicy = "trust"
+	cfg.CloneDepth = 1
+}
+
+// upstream is a package repository tests install from and commit to.
+type upstream struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{t: t, dir: dir, repo: repo}
+	u.commit("lcr-build-files/unpack.sh", "#!/bin/sh\nexit 0\n")
+	return u
+}
+
+func (u *upstream) url() string {
+	return "file://" + u.dir
+}
+
+// commit writes file and commits it, returning the new commit hash.
+func (u *upstream) commit(file, content string) string {
+	u.t.Helper()
+	path := filepath.Join(u.dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		u.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		u.t.Fatal(err)
+	}
+	w, err := u.repo.Worktree()
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	if _, err := w.Add(file); err != nil {
+		u.t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.org", When: time.Now()}
+	h, err := w.Commit("change "+file, &git.CommitOptions{Author: sig})
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	return h.String()
+}
+
+func TestUpdateShallowCloneSeveralCommitsBehind(t *testing.T) {
+	for _, depth := range []int{1, 0} {
+		useTestConfig(t)
+		cfg.CloneDepth = 1
+		u := newUpstream(t)
+		u.commit("README.md", "one\n")
+		m := &model{packages: make(map[string]*indexEntry)}
+		if err := m.installURL("pkg", u.url(), ""); err != nil {
+			t.Fatal(err)
+		}
+		u.commit("README.md", "two\n")
+		tip := u.commit("README.md", "three\n")
+
+		// An existing shallow clone must update whatever depth is set now.
+		cfg.CloneDepth = depth
+		if err := m.update("pkg"); err != nil {
+			t.Fatalf("depth %d: update: %v", depth, err)
+		}
+		if got, _ := headCommit(packageDir("pkg")); got != tip {
+			t.Fatalf("depth %d: checkout at %s, want %s", depth, got, tip)
+		}
+		data, err := os.ReadFile(filepath.Join(packageDir("pkg"), "README.md"))
+		if err != nil || string(data) != "three\n" {
+			t.Fatalf("depth %d: README.md is %q, %v", depth, data, err)
+		}
+		if err := m.update("pkg"); err != nil {
+			t.Fatalf("depth %d: second update: %v", depth, err)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	systemConfigPath = "/etc/lcr/config.toml"
+	defaultSource    = "https://raw.githubusercontent.com/LegendaryOS/lcr/main/library/repo-list.lcr"
+)
+
+// config holds every setting that used to be hard-coded. It is built from
+// defaults, then /etc/lcr/config.toml, then ~/.config/lcr/config.toml, then
+// LCR_* environment variables, then command-line flags; later layers win.
+type config struct {
+	Root            string        `toml:"root"`
+	Chroot          bool          `toml:"chroot"`
+	User            bool          `toml:"user"`
+	Prefix          string        `toml:"prefix"`
+	InstallRoot     string        `toml:"install_root"`
+	CacheDir        string        `toml:"cache_dir"`
+	StateDir        string        `toml:"state_dir"`
+	HooksDir        string        `toml:"hooks_dir"`
+	LogPath         string        `toml:"log_path"`
+	LogLevel        string        `toml:"log_level"`
+	LogFormat       string        `toml:"log_format"`
+	LogMaxSizeMB    int           `toml:"log_max_size_mb"`
+	LogMaxFiles     int           `toml:"log_max_files"`
+	Sources         []string      `toml:"sources"`
+	DownloadTimeout time.Duration `toml:"download_timeout"`
+	GitTimeout      time.Duration `toml:"git_timeout"`
+	Parallelism     int           `toml:"parallelism"`
+	CloneDepth      int           `toml:"clone_depth"`
+	AssumeYes       bool          `toml:"assume_yes"`
+	ScriptPolicy    string        `toml:"script_policy"`
+	TrustedSources  []string      `toml:"trusted_sources"`
+	AllowFileURLs   bool          `toml:"allow_file_urls"`
+	Theme           themeConfig   `toml:"theme"`
+}
+
+type themeConfig struct {
+	Primary   string `toml:"primary"`
+	Secondary string `toml:"secondary"`
+	Success   string `toml:"success"`
+	Error     string `toml:"error"`
+	Muted     string `toml:"muted"`
+	Highlight string `toml:"highlight"`
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() *config {
+	cacheDir := "/var/cache/lcr"
+	logPath := "/var/log/lcr/lcr.log"
+	if os.Geteuid() != 0 {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "lcr")
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			logPath = filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(home, ".local", "state")), "lcr", "lcr.log")
+		}
+	}
+	return &config{
+		Prefix:          "/usr",
+		InstallRoot:     "/usr/lib/lcr",
+		CacheDir:        cacheDir,
+		StateDir:        "/var/lib/lcr",
+		HooksDir:        "/etc/lcr/hooks.d",
+		LogPath:         logPath,
+		LogLevel:        "info",
+		LogFormat:       "text",
+		LogMaxSizeMB:    10,
+		LogMaxFiles:     3,
+		Sources:         []string{defaultSource},
+		DownloadTimeout: 30 * time.Second,
+		GitTimeout:      10 * time.Minute,
+		Parallelism:     4,
+		CloneDepth:      1,
+		ScriptPolicy:    "trust",
+	}
+}
+
+// applyUserMode moves everything into the invoking user's home so packages
+// can be managed without root. The per-user locations always win over
+// install_root, state_dir, cache_dir and prefix from the files, which are
+// usually written with the system in mind.
+func (c *config) applyUserMode() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	c.Prefix = filepath.Join(home, ".local")
+	c.InstallRoot = filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), "lcr")
+	c.StateDir = filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(home, ".local", "state")), "lcr")
+	c.CacheDir = filepath.Join(xdgDir("XDG_CACHE_HOME", filepath.Join(home, ".cache")), "lcr")
+	if dir, err := os.UserConfigDir(); err == nil {
+		c.HooksDir = filepath.Join(dir, "lcr", "hooks.d")
+	}
+	return nil
+}
+
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return fallback
+}
+
+// applyRoot relocates everything lcr writes below an alternate root such as
+// a mounted image.
+func (c *config) applyRoot() error {
+	if c.User {
+		return fmt.Errorf("--root cannot be combined with --user")
+	}
+	root, err := filepath.Abs(c.Root)
+	if err != nil {
+		return err
+	}
+	c.Root = root
+	c.InstallRoot = filepath.Join(root, c.InstallRoot)
+	c.StateDir = filepath.Join(root, c.StateDir)
+	c.CacheDir = filepath.Join(root, c.CacheDir)
+	c.HooksDir = filepath.Join(root, c.HooksDir)
+	return nil
+}
+
+// insideRoot turns a host path below the alternate root into the path seen
+// from inside it.
+func insideRoot(path string) string {
+	if cfg.Root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(cfg.Root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return "/" + rel
+}
+
+// packageDir is where a package is checked out.
+func packageDir(name string) string {
+	return filepath.Join(cfg.InstallRoot, name)
+}
+
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "lcr", "config.toml")
+}
+
+// configField describes one setting for the environment, flags and
+// `lcr config get/set`.
+type configField struct {
+	key    string
+	usage  string
+	isBool bool
+	get    func(c *config) any
+	set    func(c *config, v string) error
+}
+
+func stringField(key, usage string, p func(c *config) *string) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		get:   func(c *config) any { return *p(c) },
+		set: func(c *config, v string) error {
+			*p(c) = v
+			return nil
+		},
+	}
+}
+
+func boolField(key, usage string, p func(c *config) *bool) configField {
+	return configField{
+		key:    key,
+		usage:  usage,
+		isBool: true,
+		get:    func(c *config) any { return *p(c) },
+		set: func(c *config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*p(c) = b
+			return nil
+		},
+	}
+}
+
+func listField(key, usage string, p func(c *config) *[]string) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		get:   func(c *config) any { return *p(c) },
+		set: func(c *config, v string) error {
+			var list []string
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+			*p(c) = list
+			return nil
+		},
+	}
+}
+
+func intField(key, usage string, min int, p func(c *config) *int) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		get:   func(c *config) any { return *p(c) },
+		set: func(c *config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < min {
+				return fmt.Errorf("must be a number of at least %d", min)
+			}
+			*p(c) = n
+			return nil
+		},
+	}
+}
+
+func durationField(key, usage string, p func(c *config) *time.Duration) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		get:   func(c *config) any { return p(c).String() },
+		set: func(c *config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*p(c) = d
+			return nil
+		},
+	}
+}
+
+var configFields = []configField{
+	stringField("root", "Manage packages inside this root directory, e.g. a mounted image", func(c *config) *string { return &c.Root }),
+	boolField("chroot", "Run package scripts chrooted into --root", func(c *config) *bool { return &c.Chroot }),
+	boolField("user", "Install into the current user's home instead of the system", func(c *config) *bool { return &c.User }),
+	stringField("prefix", "Prefix exported to package scripts as LCR_PREFIX", func(c *config) *string { return &c.Prefix }),
+	stringField("install_root", "Directory packages are cloned into", func(c *config) *string { return &c.InstallRoot }),
+	stringField("cache_dir", "Directory for downloaded package lists", func(c *config) *string { return &c.CacheDir }),
+	stringField("state_dir", "Directory for the installed package database", func(c *config) *string { return &c.StateDir }),
+	stringField("hooks_dir", "Directory of system hooks run after each transaction", func(c *config) *string { return &c.HooksDir }),
+	stringField("log_path", "Log file", func(c *config) *string { return &c.LogPath }),
+	stringField("log_level", "Minimum level written to the log: debug, info, warn or error", func(c *config) *string { return &c.LogLevel }),
+	stringField("log_format", "Log format: text or json", func(c *config) *string { return &c.LogFormat }),
+	intField("log_max_size_mb", "Rotate the log once it grows past this many MiB", 1, func(c *config) *int { return &c.LogMaxSizeMB }),
+	intField("log_max_files", "Number of log files to keep, including the current one", 1, func(c *config) *int { return &c.LogMaxFiles }),
+	listField("sources", "Comma-separated package list URLs or paths, earlier ones win", func(c *config) *[]string { return &c.Sources }),
+	durationField("download_timeout", "Timeout for downloading package lists", func(c *config) *time.Duration { return &c.DownloadTimeout }),
+	durationField("git_timeout", "Timeout for cloning and pulling packages and downloading release archives", func(c *config) *time.Duration { return &c.GitTimeout }),
+	intField("parallelism", "Maximum number of concurrent network operations", 1, func(c *config) *int { return &c.Parallelism }),
+	intField("clone_depth", "Commits of history to clone and fetch for each package, 0 for all of it", 0, func(c *config) *int { return &c.CloneDepth }),
+	boolField("assume_yes", "Answer yes to every confirmation prompt", func(c *config) *bool { return &c.AssumeYes }),
+	{
+		key:   "script_policy",
+		usage: "trust runs package scripts as they are; review shows new and changed scripts for approval first",
+		get:   func(c *config) any { return c.ScriptPolicy },
+		set: func(c *config, v string) error {
+			if v != "trust" && v != "review" {
+				return fmt.Errorf("must be trust or review, not %q", v)
+			}
+			c.ScriptPolicy = v
+			return nil
+		},
+	},
+	listField("trusted_sources", "Comma-separated URL prefixes whose scripts never need review", func(c *config) *[]string { return &c.TrustedSources }),
+	boolField("allow_file_urls", "Allow packages from local paths and file:// URLs", func(c *config) *bool { return &c.AllowFileURLs }),
+	stringField("theme.primary", "Color of titles and selected items", func(c *config) *string { return &c.Theme.Primary }),
+	stringField("theme.secondary", "Color of subtitles and prompts", func(c *config) *string { return &c.Theme.Secondary }),
+	stringField("theme.success", "Color of success messages", func(c *config) *string { return &c.Theme.Success }),
+	stringField("theme.error", "Color of error messages", func(c *config) *string { return &c.Theme.Error }),
+	stringField("theme.muted", "Color of informational text", func(c *config) *string { return &c.Theme.Muted }),
+	stringField("theme.highlight", "Color of the header and borders", func(c *config) *string { return &c.Theme.Highlight }),
+}
+
+func findConfigField(key string) (configField, error) {
+	for _, f := range configFields {
+		if f.key == key {
+			return f, nil
+		}
+	}
+	return configField{}, fmt.Errorf("unknown config key %q", key)
+}
+
+func (f configField) envName() string {
+	return "LCR_" + strings.ToUpper(strings.NewReplacer(".", "_").Replace(f.key))
+}
+
+func (f configField) flagName() string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+// registerConfigFlags adds a flag for every setting. They are applied by
+// loadConfig so that they override the files and the environment.
+func registerConfigFlags(fs *flag.FlagSet) {
+	for _, f := range configFields {
+		if f.isBool {
+			fs.Bool(f.flagName(), false, f.usage)
+		} else {
+			fs.String(f.flagName(), "", f.usage)
+		}
+	}
+}
+
+// loadConfig builds cfg from all layers. extra is an additional file given
+// with --config; it is read after the user file.
+func loadConfig(extra string, fs *flag.FlagSet) error {
+	c := defaultConfig()
+	for _, path := range []string{systemConfigPath, userConfigPath(), extra} {
+		if path == "" {
+			continue
+		}
+		if _, err := toml.DecodeFile(path, c); errors.Is(err, os.ErrNotExist) && path != extra {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	for _, f := range configFields {
+		if v, ok := os.LookupEnv(f.envName()); ok {
+			if err := f.set(c, v); err != nil {
+				return fmt.Errorf("%s: %w", f.envName(), err)
+			}
+		}
+	}
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range configFields {
+			if f.flagName() == fl.Name && flagErr == nil {
+				if err := f.set(c, fl.Value.String()); err != nil {
+					flagErr = fmt.Errorf("--%s: %w", fl.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return flagErr
+	}
+	if c.User {
+		if err := c.applyUserMode(); err != nil {
+			return err
+		}
+	}
+	if c.Root != "" {
+		if err := c.applyRoot(); err != nil {
+			return err
+		}
+	}
+	cfg = c
+	applyTheme(cfg.Theme)
+	return nil
+}
+
+func formatConfigValue(v any) string {
+	if list, ok := v.([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// runConfigCommand implements `lcr config show|get|set`.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: lcr config show | get <key> | set [--system] <key> <value>")
+	}
+	switch args[0] {
+	case "show":
+		return toml.NewEncoder(os.Stdout).Encode(cfg)
+	case "get":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: lcr config get <key>")
+		}
+		f, err := findConfigField(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(formatConfigValue(f.get(cfg)))
+		return nil
+	case "set":
+		fs := flag.NewFlagSet("config set", flag.ContinueOnError)
+		system := fs.Bool("system", false, "Write to "+systemConfigPath+" instead of the user configuration")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: lcr config set [--system] <key> <value>")
+		}
+		path := userConfigPath()
+		if *system {
+			path = systemConfigPath
+		}
+		return setConfigValue(path, fs.Arg(0), fs.Arg(1))
+	}
+	return fmt.Errorf("unknown config command %q", args[0])
+}
+
+// setConfigValue changes a single key in a config file and leaves the rest
+// of the file alone, so that unset keys keep falling through to lower layers.
+func setConfigValue(path, key, value string) error {
+	f, err := findConfigField(key)
+	if err != nil {
+		return err
+	}
+	scratch := defaultConfig()
+	if err := f.set(scratch, value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	data := make(map[string]any)
+	if _, err := toml.DecodeFile(path, &data); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	table := data
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		sub, ok := table[part].(map[string]any)
+		if !ok {
+			sub = make(map[string]any)
+			table[part] = sub
+		}
+		table = sub
+	}
+	table[parts[len(parts)-1]] = f.get(scratch)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	slog.Info("Setting config value", "key", key, "value", value, "file", path)
+	return toml.NewEncoder(out).Encode(data)
+}
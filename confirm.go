@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+var errAborted = errors.New("aborted")
+
+// registerYesFlags adds --yes and -y to a subcommand. Both only ever turn
+// assume_yes on, so LCR_ASSUME_YES and the config file keep working.
+func registerYesFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cfg.AssumeYes, "yes", cfg.AssumeYes, "Do not ask for confirmation")
+	fs.BoolVar(&cfg.AssumeYes, "y", cfg.AssumeYes, "Shorthand for --yes")
+}
+
+// confirm prints the plan for action and asks whether to go ahead. Without a
+// terminal to ask on, destructive actions are refused and the rest go ahead.
+func confirm(action string, destructive bool, plan func() error) error {
+	if cfg.AssumeYes {
+		return nil
+	}
+	if !stdinIsTerminal() {
+		if destructive {
+			return fmt.Errorf("refusing to %s without confirmation; pass --yes or set LCR_ASSUME_YES=1", action)
+		}
+		return nil
+	}
+	if err := plan(); err != nil {
+		return err
+	}
+	if !askYesNo("Proceed?") {
+		return errAborted
+	}
+	return nil
+}
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// askYesNo asks a question on the terminal. Anything but y or yes is no.
+func askYesNo(question string) bool {
+	fmt.Print(question + " [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// confirmOrExit is confirm for the CLI: it exits unless the answer is yes.
+func confirmOrExit(action string, destructive bool, plan func() error) {
+	err := confirm(action, destructive, plan)
+	if err == errAborted {
+		fmt.Println("Aborted.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// askConfirm shows the confirmation screen for m.choice on m.pakiets. Going
+// back from it returns to back.
+func (m *model) askConfirm(back state) (tea.Model, tea.Cmd) {
+	if cfg.AssumeYes {
+		m.state = stateExec
+		return m, m.startExec()
+	}
+	m.confirmBack = back
+	m.state = stateConfirm
+	return m, nil
+}
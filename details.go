@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const readmeExcerptLines = 15
+
+// packageDetails is everything the detail pane shows about a package.
+type packageDetails struct {
+	name       string
+	url        string
+	installed  *installedPackage
+	readme     string
+	buildFiles []string
+	manifest   *manifest
+	version    string
+}
+
+type detailsLoadedMsg struct {
+	details *packageDetails
+	err     error
+}
+
+// fetchDetails reads the README and lcr-build-files of a package, from the
+// installed checkout when there is one and from a shallow in-memory clone
+// or the downloaded archive otherwise. e is nil for packages not in the
+// index.
+func fetchDetails(name string, e *indexEntry) (*packageDetails, error) {
+	slog.Debug("Fetching package details", "package", name)
+	d := &packageDetails{name: name}
+	if e != nil {
+		d.url, d.version = e.URL, e.Version
+	}
+	url := d.url
+	installed, err := listInstalled()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range installed {
+		if p.Name == name {
+			d.installed = p
+			if d.url == "" {
+				d.url = p.URL
+			}
+		}
+	}
+	var fs billy.Filesystem
+	if d.installed != nil {
+		fs = osfs.New(packageDir(name))
+	} else if isArchiveURL(url) {
+		dir, err := os.MkdirTemp("", "lcr-details-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if err := unpackArchive(archiveSourceFor(e), filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+		fs = osfs.New(filepath.Join(dir, name))
+	} else {
+		fs = memfs.New()
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+		defer cancel()
+		_, err := git.CloneContext(ctx, memory.NewStorage(), fs, &git.CloneOptions{URL: url, Depth: 1})
+		if err != nil {
+			return nil, fmt.Errorf("clone %s: %w", url, err)
+		}
+	}
+	d.readme = readmeExcerpt(fs)
+	if d.manifest, err = readManifest(fs); err != nil {
+		slog.Warn("Could not read manifest", "package", name, "err", err)
+	}
+	switch {
+	case d.installed != nil && d.installed.Version != "":
+		d.version = d.installed.Version
+	case d.version == "" && d.manifest != nil:
+		d.version = d.manifest.Version
+	}
+	entries, err := fs.ReadDir("lcr-build-files")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		d.buildFiles = append(d.buildFiles, name)
+	}
+	return d, nil
+}
+
+func readmeExcerpt(fs billy.Filesystem) string {
+	for _, name := range []string{"README.md", "README", "README.txt", "readme.md"} {
+		f, err := fs.Open(name)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(f, 16*1024))
+		f.Close()
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) > readmeExcerptLines {
+			lines = append(lines[:readmeExcerptLines], "...")
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
+func (m *model) startDetails(name string) tea.Cmd {
+	e := m.packages[name]
+	m.status = fmt.Sprintf("Fetching details for %s...", name)
+	m.outputLines = nil
+	m.output.SetContent("")
+	m.stage = ""
+	load := func() tea.Msg {
+		d, err := fetchDetails(name, e)
+		return detailsLoadedMsg{details: d, err: err}
+	}
+	return tea.Batch(m.spinner.Tick, load)
+}
+
+func (m *model) showDetails(msg detailsLoadedMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		slog.Error("Could not fetch package details", "err", msg.err)
+		m.result = errorStyle.Render(fmt.Sprintf("Error: %v", msg.err))
+		m.state = stateResult
+		return m, nil
+	}
+	m.details = msg.details
+	m.state = stateDetails
+	return m, nil
+}
+
+func (m *model) updateDetails(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+	installed := m.details.installed != nil
+	switch key.String() {
+	case "esc", "q":
+		m.state = stateList
+		return m, nil
+	case "i":
+		if installed {
+			return m, nil
+		}
+		m.choice = "install"
+	case "u":
+		if !installed {
+			return m, nil
+		}
+		m.choice = "update"
+	case "r":
+		if !installed {
+			return m, nil
+		}
+		m.choice = "remove"
+	default:
+		return m, nil
+	}
+	m.pakiets = []string{m.details.name}
+	slog.Debug("Action selected from details view", "action", m.choice, "package", m.details.name)
+	return m.askConfirm(stateDetails)
+}
+
+func (m *model) detailsView() string {
+	d := m.details
+	var b strings.Builder
+	if d.installed != nil && d.installed.Local {
+		fmt.Fprintf(&b, "Source:     %s (local)\n", d.url)
+	} else {
+		fmt.Fprintf(&b, "Source:     %s\n", d.url)
+	}
+	if d.installed != nil {
+		revision := "commit"
+		if isArchivePackage(packageDir(d.name)) {
+			revision = "sha256"
+		}
+		fmt.Fprintf(&b, "Installed:  yes, %s %s on %s\n",
+			revision, shortCommit(d.installed.Commit), d.installed.InstalledAt.Format("2006-01-02"))
+	} else {
+		b.WriteString("Installed:  no\n")
+	}
+	if d.version != "" {
+		fmt.Fprintf(&b, "%-11s %s\n", "Version:", d.version)
+	}
+	if mf := d.manifest; mf != nil {
+		for _, field := range []struct {
+			label string
+			value string
+		}{
+			{"About:", mf.Description},
+			{"Depends:", strings.Join(mf.Depends, ", ")},
+			{"Provides:", strings.Join(mf.Provides, ", ")},
+			{"Conflicts:", strings.Join(mf.Conflicts, ", ")},
+			{"Requires:", strings.Join(mf.Requires, ", ")},
+			{"Arch:", strings.Join(mf.Arch, ", ")},
+		} {
+			if field.value != "" {
+				fmt.Fprintf(&b, "%-11s %s\n", field.label, field.value)
+			}
+		}
+	}
+	b.WriteString("\nBuild files:\n")
+	if len(d.buildFiles) == 0 {
+		b.WriteString("  (no lcr-build-files directory)\n")
+	}
+	for _, f := range d.buildFiles {
+		fmt.Fprintf(&b, "  %s\n", f)
+	}
+	if d.readme != "" {
+		b.WriteString("\nREADME:\n")
+		b.WriteString(d.readme)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (m *model) detailsActions() string {
+	if m.details.installed != nil {
+		return "u update | r remove | esc back"
+	}
+	return "i install | esc back"
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// The plan* functions print what install, remove, update and upgrade would
+// do without touching the install root or running any script. Only
+// planUpdate writes anything: it fetches, so the commit delta it shows is
+// the one a real update would apply.
+
+func (m *model) planInstall(pakiet string) error {
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	entry, ok := m.packages[pakiet]
+	if !ok {
+		return fmt.Errorf("package %s not found", pakiet)
+	}
+	dest := packageDir(pakiet)
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("package %s is already installed in %s", pakiet, dest)
+	}
+	if isArchiveURL(entry.URL) {
+		out := m.out()
+		fmt.Fprintf(out, "Would install %s:\n", pakiet)
+		fmt.Fprintf(out, "  download %s\n", entry.URL)
+		fmt.Fprintf(out, "  verify sha256 %s\n", entry.SHA256)
+		fmt.Fprintf(out, "  extract into %s\n", dest)
+		fmt.Fprintf(out, "  run lcr-build-files/unpack.sh\n")
+		return nil
+	}
+	commit, err := remoteHead(entry.URL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", entry.URL, err)
+	}
+	out := m.out()
+	fmt.Fprintf(out, "Would install %s:\n", pakiet)
+	fmt.Fprintf(out, "  clone %s at %s\n", entry.URL, shortCommit(commit))
+	fmt.Fprintf(out, "  into %s\n", dest)
+	fmt.Fprintf(out, "  run lcr-build-files/unpack.sh\n")
+	return nil
+}
+
+func (m *model) planRemove(pakiet string) error {
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	dest := packageDir(pakiet)
+	if _, err := os.Stat(dest); err != nil {
+		return fmt.Errorf("package %s is not installed", pakiet)
+	}
+	out := m.out()
+	commit, _ := headCommit(dest)
+	fmt.Fprintf(out, "Would remove %s at %s:\n", pakiet, commitOrNone(commit))
+	for _, script := range []string{filepath.Join(dest, "lcr-build-files", hookPreRemove), packageScript(dest, "remove")} {
+		if _, err := os.Stat(script); err == nil {
+			fmt.Fprintf(out, "  run lcr-build-files/%s\n", filepath.Base(script))
+		}
+	}
+	if db, err := loadInstalledDB(); err == nil && db.Packages[pakiet] != nil {
+		for _, f := range db.Packages[pakiet].Files {
+			fmt.Fprintf(out, "  delete %s\n", f)
+		}
+	}
+	fmt.Fprintf(out, "  delete %s\n", dest)
+	return filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dest {
+			return nil
+		}
+		rel, _ := filepath.Rel(dest, path)
+		if d.IsDir() && d.Name() == ".git" {
+			fmt.Fprintf(out, "    %s/ (git metadata)\n", rel)
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			fmt.Fprintf(out, "    %s\n", rel)
+		}
+		return nil
+	})
+}
+
+func (m *model) planUpdate(pakiet string) error {
+	if err := validatePackageName(pakiet); err != nil {
+		return err
+	}
+	dest := packageDir(pakiet)
+	if src, err := readArchiveMarker(dest); err == nil {
+		return m.planArchiveUpdate(pakiet, src)
+	}
+	repo, err := git.PlainOpen(dest)
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if url, _ := originURL(dest); isLocalDir(url) {
+		return m.planLocalUpdate(pakiet, url, head.Hash().String())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	remoteRef, err := m.fetchTip(ctx, repo)
+	if err != nil {
+		return fmt.Errorf("no upstream for %s: %w", head.Name().Short(), err)
+	}
+	out := m.out()
+	if remoteRef.Hash() == head.Hash() {
+		fmt.Fprintf(out, "%s is already the latest version (%s).\n", pakiet, shortCommit(head.Hash().String()))
+		return nil
+	}
+	fmt.Fprintf(out, "Would update %s from %s to %s:\n", pakiet,
+		shortCommit(head.Hash().String()), shortCommit(remoteRef.Hash().String()))
+	commits, err := repo.Log(&git.LogOptions{From: remoteRef.Hash()})
+	if err != nil {
+		return err
+	}
+	errStop := errors.New("stop")
+	err = commits.ForEach(func(c *object.Commit) error {
+		if c.Hash == head.Hash() {
+			return errStop
+		}
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		fmt.Fprintf(out, "  %s %s\n", shortCommit(c.Hash.String()), subject)
+		return nil
+	})
+	// A missing object is where a shallow clone's history ends.
+	if err != nil && err != errStop && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return err
+	}
+	fmt.Fprintf(out, "  run lcr-build-files/unpack.sh\n")
+	return nil
+}
+
+// planLocalUpdate prints what update does to a package tracking a working
+// tree, which is unpacking it again even when nothing was committed.
+func (m *model) planLocalUpdate(pakiet, src, head string) error {
+	tip, err := headCommit(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	out := m.out()
+	if tip == head {
+		fmt.Fprintf(out, "Would reinstall local package %s at %s from %s:\n", pakiet, shortCommit(head), src)
+	} else {
+		fmt.Fprintf(out, "Would update local package %s from %s to %s from %s:\n", pakiet, shortCommit(head), shortCommit(tip), src)
+	}
+	fmt.Fprintf(out, "  copy its uncommitted changes\n")
+	fmt.Fprintf(out, "  run lcr-build-files/unpack.sh\n")
+	return nil
+}
+
+func (m *model) planArchiveUpdate(pakiet string, src *archiveSource) error {
+	e, ok := m.packages[pakiet]
+	if !ok {
+		return fmt.Errorf("%s is not in the index", pakiet)
+	}
+	out := m.out()
+	if e.SHA256 == src.SHA256 {
+		fmt.Fprintf(out, "%s is already the latest version (%s).\n", pakiet, shortCommit(src.SHA256))
+		return nil
+	}
+	from, to := shortCommit(src.SHA256), shortCommit(e.SHA256)
+	if src.Version != "" && e.Version != "" {
+		from, to = src.Version, e.Version
+	}
+	fmt.Fprintf(out, "Would update %s from %s to %s:\n", pakiet, from, to)
+	fmt.Fprintf(out, "  download %s\n", e.URL)
+	fmt.Fprintf(out, "  replace %s and run lcr-build-files/unpack.sh\n", packageDir(pakiet))
+	return nil
+}
+
+// planAutoremove prints the removal plan of every package autoremove would
+// remove.
+func (m *model) planAutoremove() error {
+	names, err := unneededPackages()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(m.out(), "No unneeded dependencies to remove.")
+		return nil
+	}
+	var errs []error
+	for _, name := range names {
+		if err := m.planRemove(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *model) planUpgrade() error {
+	pkgs, err := listInstalled()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range pkgs {
+		if err := m.planUpdate(p.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// remoteHead returns the commit the default branch of url points at.
+func remoteHead(url string) (string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference)
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+	ref, ok := byName[plumbing.HEAD]
+	if ok && ref.Type() == plumbing.SymbolicReference {
+		ref, ok = byName[ref.Target()]
+	}
+	if !ok {
+		return "", errors.New("remote has no HEAD")
+	}
+	return ref.Hash().String(), nil
+}
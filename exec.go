@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Messages sent from a running operation back to the TUI.
+type execOutputMsg string
+
+type execProgressMsg struct {
+	stage   string
+	percent float64
+}
+
+// scriptReviewMsg asks the TUI to approve a script. The operation waits
+// for the answer on reply.
+type scriptReviewMsg struct {
+	review scriptReview
+	reply  chan<- bool
+}
+
+type execDoneMsg struct {
+	packages  map[string]*indexEntry
+	installed []*installedPackage
+	result    string
+	err       error
+}
+
+// outputWriter splits script output into lines and forwards them to the TUI.
+type outputWriter struct {
+	mu  sync.Mutex
+	ch  chan<- tea.Msg
+	buf []byte
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		if line != "" {
+			w.ch <- execOutputMsg(line)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends whatever is left in the buffer without a trailing newline.
+func (w *outputWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.ch <- execOutputMsg(string(w.buf))
+		w.buf = nil
+	}
+}
+
+var progressRe = regexp.MustCompile(`([A-Za-z ]+):\s+(\d+)%`)
+
+// progressWriter parses go-git sideband progress ("Receiving objects:  45% (9/20)")
+// and forwards it to the TUI.
+type progressWriter struct {
+	ch chan<- tea.Msg
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	matches := progressRe.FindAllStringSubmatch(string(p), -1)
+	if len(matches) == 0 {
+		return len(p), nil
+	}
+	last := matches[len(matches)-1]
+	pct, err := strconv.Atoi(last[2])
+	if err == nil {
+		w.ch <- execProgressMsg{stage: strings.TrimSpace(last[1]), percent: float64(pct) / 100}
+	}
+	return len(p), nil
+}
+
+// waitForEvent blocks until the running operation reports something.
+func waitForEvent(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+// startExec runs the selected command in the background and returns the
+// commands that feed its output, progress and result back into Update.
+func (m *model) startExec() tea.Cmd {
+	ch := make(chan tea.Msg, 64)
+	m.events = ch
+	m.outputLines = nil
+	m.output.SetContent("")
+	m.stage = ""
+	m.percent = 0
+	m.status = fmt.Sprintf("Running %s...", m.choice)
+	choice, pakiets := m.choice, m.pakiets
+	slog.Debug("Starting operation in background", "action", choice)
+	go func() {
+		ch <- runOperation(choice, pakiets, ch)
+		close(ch)
+	}()
+	return tea.Batch(m.spinner.Tick, waitForEvent(ch))
+}
+
+// runOperation performs a command on a separate model so the TUI model is
+// only ever touched from the Bubble Tea loop.
+func runOperation(choice string, pakiets []string, ch chan<- tea.Msg) execDoneMsg {
+	out := &outputWriter{ch: ch}
+	w := &model{
+		packages:    make(map[string]*indexEntry),
+		stdout:      out,
+		gitProgress: &progressWriter{ch: ch},
+	}
+	w.reviewer = func(r scriptReview) bool {
+		reply := make(chan bool)
+		ch <- scriptReviewMsg{review: r, reply: reply}
+		return <-reply
+	}
+	defer out.Flush()
+	if err := w.loadPackages(); err != nil {
+		slog.Error("Could not load packages", "err", err)
+		return execDoneMsg{err: err}
+	}
+	var installed []*installedPackage
+	var err error
+	if choice != "installed" {
+		w.begin(choice)
+	}
+	switch choice {
+	case "install", "remove", "reinstall", "update":
+		var errs []error
+		for _, pakiet := range pakiets {
+			if err := w.apply(choice, pakiet); err != nil {
+				if len(pakiets) > 1 {
+					err = fmt.Errorf("%s: %w", pakiet, err)
+				}
+				errs = append(errs, err)
+			}
+		}
+		err = errors.Join(errs...)
+	case "upgrade":
+		err = w.upgrade()
+	case "installed":
+		installed, err = listInstalled()
+		checkUpgrades(installed, w.packages)
+	}
+	w.commit(err)
+	return execDoneMsg{packages: w.packages, installed: installed, result: w.result, err: err}
+}
+
+// apply runs a single-package command.
+func (m *model) apply(choice, pakiet string) error {
+	switch choice {
+	case "install":
+		return m.install(pakiet)
+	case "remove":
+		return m.remove(pakiet)
+	case "reinstall":
+		return m.reinstall(pakiet)
+	case "update":
+		return m.update(pakiet)
+	}
+	return fmt.Errorf("unknown command %s", choice)
+}
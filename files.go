@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// installEntry is one [[install]] table of a manifest. It copies source, a
+// file or directory in the package checkout, to dest, or makes dest a
+// symlink to link:
+//
+//	[[install]]
+//	source = "build/vira"
+//	dest = "bin/vira"
+//	mode = "0755"
+//
+//	[[install]]
+//	dest = "bin/v"
+//	link = "vira"
+//
+// A relative dest is below the prefix.
+type installEntry struct {
+	Source string `toml:"source"`
+	Dest   string `toml:"dest"`
+	Mode   string `toml:"mode"`
+	Link   string `toml:"link"`
+}
+
+func (e installEntry) validate() error {
+	switch {
+	case e.Dest == "":
+		return fmt.Errorf("install entry without dest")
+	case !filepath.IsAbs(e.Dest) && !filepath.IsLocal(e.Dest):
+		return fmt.Errorf("install dest %q leaves the prefix", e.Dest)
+	case slices.Contains(strings.Split(filepath.ToSlash(e.Dest), "/"), ".."):
+		return fmt.Errorf("install dest %q contains ..", e.Dest)
+	case e.Link == "" && e.Source == "":
+		return fmt.Errorf("install entry for %s needs a source or a link", e.Dest)
+	case e.Link != "" && e.Source != "":
+		return fmt.Errorf("install entry for %s has both a source and a link", e.Dest)
+	case e.Source != "" && !filepath.IsLocal(e.Source):
+		return fmt.Errorf("install source %q must be a path inside the package", e.Source)
+	}
+	if _, err := e.mode(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// mode is the permission bits for installed files, 0 to keep the source's.
+func (e installEntry) mode() (fs.FileMode, error) {
+	if e.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(e.Mode, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("install mode %q for %s is not an octal permission", e.Mode, e.Dest)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// target is where an entry installs, as seen from inside the root.
+func (e installEntry) target() string {
+	if filepath.IsAbs(e.Dest) {
+		return filepath.Clean(e.Dest)
+	}
+	return filepath.Join(cfg.Prefix, e.Dest)
+}
+
+// hostPath turns a path as seen from inside the root into one lcr can open.
+func hostPath(path string) string {
+	if cfg.Root == "" {
+		return path
+	}
+	return filepath.Join(cfg.Root, path)
+}
+
+// fileOwners maps every file recorded in the installed database to the
+// package that owns it.
+func fileOwners() (map[string]string, error) {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string)
+	for name, p := range db.Packages {
+		for _, f := range p.Files {
+			owners[f] = name
+		}
+	}
+	return owners, nil
+}
+
+// installFiles performs the [[install]] entries of a manifest. Files that
+// exist already must belong to the package itself; files it owned before
+// and no longer installs are removed.
+func (m *model) installFiles(pakiet string, mf *manifest) error {
+	dir := packageDir(pakiet)
+	owners, err := fileOwners()
+	if err != nil {
+		return err
+	}
+	type op struct {
+		entry  installEntry
+		source string
+		target string
+	}
+	var ops []op
+	for _, e := range mf.Install {
+		if e.Link != "" {
+			ops = append(ops, op{entry: e, target: e.target()})
+			continue
+		}
+		src, err := packagePath(dir, e.Source)
+		if err != nil {
+			return fmt.Errorf("install %s: %w", e.Source, err)
+		}
+		err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				// It could point anywhere on the system; use link instead.
+				return fmt.Errorf("%s is a symlink", path)
+			}
+			rel, _ := filepath.Rel(src, path)
+			ops = append(ops, op{entry: e, source: path, target: filepath.Join(e.target(), rel)})
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("install %s: %w", e.Source, err)
+		}
+	}
+	for _, o := range ops {
+		if owner, ok := owners[o.target]; ok && owner != pakiet {
+			return fmt.Errorf("%s is already owned by %s", o.target, owner)
+		}
+		if _, ok := owners[o.target]; !ok {
+			if _, err := os.Lstat(hostPath(o.target)); err == nil {
+				return fmt.Errorf("%s already exists and does not belong to any package", o.target)
+			}
+		}
+	}
+
+	var files []string
+	for _, o := range ops {
+		slog.Debug("Installing file", "package", pakiet, "path", o.target)
+		if o.entry.Link != "" {
+			err = installSymlink(o.entry.Link, hostPath(o.target))
+		} else {
+			mode, _ := o.entry.mode()
+			err = installFile(o.source, hostPath(o.target), mode)
+		}
+		if err != nil {
+			// Own what is on disk so that a retry or remove can deal with it.
+			for f, owner := range owners {
+				if owner == pakiet && !slices.Contains(files, f) {
+					files = append(files, f)
+				}
+			}
+			if rerr := recordFiles(pakiet, files); rerr != nil {
+				slog.Error("Could not record installed files", "package", pakiet, "err", rerr)
+			}
+			return err
+		}
+		files = append(files, o.target)
+		fmt.Fprintf(m.out(), "installed %s\n", o.target)
+	}
+	for f, owner := range owners {
+		if owner == pakiet && !slices.Contains(files, f) {
+			removeOwnedFile(f)
+		}
+	}
+	return recordFiles(pakiet, files)
+}
+
+// packagePath resolves source inside the package checkout dir. Symlinks
+// are refused anywhere along the way, not just at the end, since they could
+// point anywhere on the system.
+func packagePath(dir, source string) (string, error) {
+	path := dir
+	for _, part := range strings.Split(filepath.Clean(source), string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is a symlink", path)
+		}
+	}
+	return path, nil
+}
+
+// installFile copies src to dst through a temporary file so a running
+// program never sees half a binary.
+func installFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if mode == 0 {
+		info, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".lcr-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func installSymlink(link, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(link, dst)
+}
+
+// removeOwnedFile deletes an installed file and any directories above it
+// that are left empty, stopping at the prefix.
+func removeOwnedFile(path string) {
+	if err := os.Remove(hostPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Could not remove file", "path", path, "err", err)
+		return
+	}
+	for dir := filepath.Dir(path); dir != cfg.Prefix && dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if os.Remove(hostPath(dir)) != nil {
+			break
+		}
+	}
+}
+
+// removeFiles deletes every file recorded for a package.
+func (m *model) removeFiles(pakiet string) error {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return err
+	}
+	p, ok := db.Packages[pakiet]
+	if !ok {
+		return nil
+	}
+	for _, f := range p.Files {
+		removeOwnedFile(f)
+		fmt.Fprintf(m.out(), "removed %s\n", f)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallFilesRefusesSymlinkedSources(t *testing.T) {
+	useTestConfig(t)
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := packageDir("pkg")
+	if err := os.MkdirAll(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "real", "tool"), []byte("tool"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"dir", "root"} {
+		if err := os.Symlink(outside, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &model{packages: make(map[string]*indexEntry)}
+	for _, source := range []string{"dir/secret", "root"} {
+		mf := &manifest{Install: []installEntry{{Source: source, Dest: "share/stolen"}}}
+		if err := m.installFiles("pkg", mf); err == nil {
+			t.Errorf("installing %s succeeded, want an error", source)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(cfg.Prefix, "share")); err == nil {
+		t.Error("files were copied from outside the package")
+	}
+
+	mf := &manifest{Install: []installEntry{{Source: "real/tool", Dest: "bin/tool"}}}
+	if err := m.installFiles("pkg", mf); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(cfg.Prefix, "bin", "tool")); err != nil || string(data) != "tool" {
+		t.Fatalf("bin/tool is %q, %v", data, err)
+	}
+}
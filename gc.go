@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// leftoverAge is how old a temporary file from an interrupted install must
+// be before `lcr gc` deletes it, so that it leaves a running lcr alone.
+const leftoverAge = time.Hour
+
+// dirSize returns the total size of the files below dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	f, suffix := float64(n), ""
+	for _, suffix = range []string{"KiB", "MiB", "GiB", "TiB"} {
+		f /= unit
+		if f < unit && f > -unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", f, suffix)
+}
+
+// reachableObjects returns every object reachable from the references in s.
+// Unlike the walk go-git prunes and repacks with, it stops at parents that
+// a shallow clone does not have, and also returns the commits it stopped at.
+func reachableObjects(s storage.Storer) (map[plumbing.Hash]bool, []plumbing.Hash, error) {
+	seen := make(map[plumbing.Hash]bool)
+	var stack, shallow []plumbing.Hash
+	refs, err := s.IterReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			stack = append(stack, ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		obj, err := object.GetObject(s, h)
+		if err != nil {
+			return nil, nil, fmt.Errorf("object %s: %w", h, err)
+		}
+		switch obj := obj.(type) {
+		case *object.Commit:
+			stack = append(stack, obj.TreeHash)
+			missing := false
+			for _, parent := range obj.ParentHashes {
+				if s.HasEncodedObject(parent) != nil {
+					missing = true
+					continue
+				}
+				stack = append(stack, parent)
+			}
+			if missing {
+				shallow = append(shallow, h)
+			}
+		case *object.Tree:
+			for _, e := range obj.Entries {
+				switch {
+				case e.Mode == filemode.Submodule:
+					// Submodule commits live in another repository.
+				case e.Mode == filemode.Dir:
+					stack = append(stack, e.Hash)
+				default:
+					seen[e.Hash] = true
+				}
+			}
+		case *object.Tag:
+			stack = append(stack, obj.Target)
+		}
+	}
+	return seen, shallow, nil
+}
+
+// gcRepo packs the objects reachable in the repository in dir into a single
+// pack and deletes everything else.
+func gcRepo(dir string) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	s := repo.Storer
+	pos, ok := s.(storer.PackedObjectStorer)
+	if !ok {
+		return git.ErrPackedObjectsNotSupported
+	}
+	los, ok := s.(storer.LooseObjectStorer)
+	if !ok {
+		return git.ErrLooseObjectsNotSupported
+	}
+	seen, shallow, err := reachableObjects(s)
+	if err != nil {
+		return err
+	}
+	oldPacks, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+	objs := make([]plumbing.Hash, 0, len(seen))
+	for h := range seen {
+		objs = append(objs, h)
+	}
+	w, err := s.(storer.PackfileWriter).PackfileWriter()
+	if err != nil {
+		return err
+	}
+	pack, err := packfile.NewEncoder(w, s, false).Encode(objs, 10)
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("repack: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("repack: %w", err)
+	}
+	for _, h := range oldPacks {
+		if h == pack {
+			continue
+		}
+		if err := pos.DeleteOldObjectPackAndIndex(h, time.Time{}); err != nil {
+			return err
+		}
+	}
+	err = los.ForEachObjectHash(func(h plumbing.Hash) error {
+		return los.DeleteLooseObject(h)
+	})
+	if err != nil {
+		return err
+	}
+	if old, _ := s.Shallow(); len(old) == 0 && len(shallow) == 0 {
+		return nil
+	}
+	// History behind the pruned commits is gone for good now.
+	return s.SetShallow(shallow)
+}
+
+// isLeftover tells whether name is a temporary file or directory that an
+// interrupted install, update or download left behind.
+func isLeftover(name string) bool {
+	for _, prefix := range []string{".extract-", ".download-", ".old-", ".full-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLeftovers deletes old leftovers in dir and returns the space freed.
+// Installed packages are never touched, whatever their name.
+func removeLeftovers(dir string, dryRun bool) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	db, err := loadInstalledDB()
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	for _, e := range entries {
+		if _, ok := db.Packages[e.Name()]; ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !isLeftover(e.Name()) || time.Since(info.ModTime()) < leftoverAge {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		size := info.Size()
+		if e.IsDir() {
+			size = dirSize(path)
+		}
+		fmt.Printf("%-24s %10s  leftover in %s\n", e.Name(), formatSize(size), dir)
+		if dryRun {
+			freed += size
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return freed, err
+		}
+		freed += size
+	}
+	return freed, nil
+}
+
+// runGCCommand implements `lcr gc [--dry-run] [package]...`.
+func runGCCommand(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only report what would be cleaned up")
+	fs.Parse(args)
+	names := fs.Args()
+	if len(names) == 0 {
+		pkgs, err := listInstalled()
+		if err != nil {
+			return err
+		}
+		for _, p := range pkgs {
+			names = append(names, p.Name)
+		}
+	}
+
+	var saved int64
+	failed := 0
+	for _, name := range names {
+		if err := validatePackageName(name); err != nil {
+			return err
+		}
+		dir := packageDir(name)
+		if isArchivePackage(dir) {
+			continue
+		}
+		gitDir := filepath.Join(dir, ".git")
+		if _, err := os.Stat(gitDir); err != nil {
+			return fmt.Errorf("%s is not installed", name)
+		}
+		before := dirSize(gitDir)
+		if *dryRun {
+			fmt.Printf("%-24s %10s\n", name, formatSize(before))
+			continue
+		}
+		if err := gcRepo(dir); err != nil {
+			slog.Error("Garbage collection failed", "package", name, "err", err)
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed++
+			continue
+		}
+		after := dirSize(gitDir)
+		saved += before - after
+		fmt.Printf("%-24s %10s -> %s\n", name, formatSize(before), formatSize(after))
+	}
+
+	for _, dir := range []string{cfg.InstallRoot, filepath.Join(cfg.CacheDir, "archives")} {
+		freed, err := removeLeftovers(dir, *dryRun)
+		saved += freed
+		if err != nil {
+			return err
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+	fmt.Printf("Saved %s.\n", formatSize(saved))
+	if failed > 0 {
+		return fmt.Errorf("%d packages could not be cleaned up", failed)
+	}
+	return nil
+}
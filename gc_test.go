@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// checkRepo fails the test unless every object reachable from the
+// references of the repository in dir can be read, stopping only at the
+// commits its shallow file lists.
+func checkRepo(t *testing.T, dir string) {
+	t.Helper()
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shallow := make(map[plumbing.Hash]bool)
+	hashes, err := repo.Storer.Shallow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hashes {
+		shallow[h] = true
+	}
+	refs, err := repo.References()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[plumbing.Hash]bool)
+	var walk func(h plumbing.Hash)
+	walk = func(h plumbing.Hash) {
+		if seen[h] {
+			return
+		}
+		seen[h] = true
+		c, err := repo.CommitObject(h)
+		if err != nil {
+			t.Fatalf("commit %s: %v", h, err)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			t.Fatalf("tree of %s: %v", h, err)
+		}
+		err = tree.Files().ForEach(func(f *object.File) error {
+			_, err := f.Contents()
+			return err
+		})
+		if err != nil {
+			t.Fatalf("files of %s: %v", h, err)
+		}
+		if shallow[h] {
+			return
+		}
+		for _, parent := range c.ParentHashes {
+			walk(parent)
+		}
+	}
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			walk(ref.Hash())
+		}
+		return nil
+	})
+	if _, err := exec.LookPath("git"); err == nil {
+		if out, err := exec.Command("git", "-C", dir, "fsck", "--full", "--no-dangling").CombinedOutput(); err != nil {
+			t.Fatalf("git fsck: %v\n%s", err, out)
+		}
+	}
+}
+
+// gcUpstream returns an upstream with a few commits.
+func gcUpstream(t *testing.T) (*upstream, []string) {
+	u := newUpstream(t)
+	var commits []string
+	for _, content := range []string{"one\n", "two\n", "three\n"} {
+		commits = append(commits, u.commit("README.md", content))
+	}
+	return u, commits
+}
+
+// addGarbage stores an object nothing refers to and returns its hash.
+func addGarbage(t *testing.T, repo *git.Repository) plumbing.Hash {
+	t.Helper()
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("garbage"))
+	w.Close()
+	h, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestGCFullClone(t *testing.T) {
+	u, commits := gcUpstream(t)
+	dir := t.TempDir()
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{URL: u.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := addGarbage(t, repo)
+	if err := gcRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir)
+	repo, _ = git.PlainOpen(dir)
+	if repo.Storer.HasEncodedObject(garbage) == nil {
+		t.Error("unreachable object survived gc")
+	}
+	for _, c := range commits {
+		if _, err := repo.CommitObject(plumbing.NewHash(c)); err != nil {
+			t.Errorf("commit %s lost: %v", c, err)
+		}
+	}
+	if hashes, _ := repo.Storer.Shallow(); len(hashes) != 0 {
+		t.Errorf("a full clone became shallow: %v", hashes)
+	}
+}
+
+func TestGCShallowClone(t *testing.T) {
+	useTestConfig(t)
+	u, _ := gcUpstream(t)
+	m := &model{packages: make(map[string]*indexEntry)}
+	if err := m.installURL("pkg", u.url(), ""); err != nil {
+		t.Fatal(err)
+	}
+	u.commit("README.md", "four\n")
+	tip := u.commit("README.md", "five\n")
+	if err := m.update("pkg"); err != nil {
+		t.Fatal(err)
+	}
+	dir := packageDir("pkg")
+	if err := gcRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir)
+	repo, _ := git.PlainOpen(dir)
+	hashes, err := repo.Storer.Shallow()
+	if err != nil || len(hashes) == 0 {
+		t.Fatalf("shallow list after gc: %v, %v", hashes, err)
+	}
+	if got, _ := headCommit(dir); got != tip {
+		t.Fatalf("checkout at %s, want %s", got, tip)
+	}
+	// The repository keeps working: it can update again after gc.
+	tip = u.commit("README.md", "six\n")
+	if err := m.update("pkg"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := headCommit(dir); got != tip {
+		t.Fatalf("checkout at %s after gc and update, want %s", got, tip)
+	}
+	checkRepo(t, dir)
+}
+
+func TestGCKeepsLCRRefs(t *testing.T) {
+	u, commits := gcUpstream(t)
+	dir := t.TempDir()
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{URL: u.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the branch back and forget the remote, so only the lcr ref
+	// keeps the last commit alive.
+	last := plumbing.NewHash(commits[len(commits)-1])
+	w, _ := repo.Worktree()
+	if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(commits[0]), Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	refs, _ := repo.References()
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsRemote() {
+			repo.Storer.RemoveReference(ref.Name())
+		}
+		return nil
+	})
+	keep := plumbing.ReferenceName("refs/lcr/" + last.String())
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(keep, last)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gcRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir)
+	repo, _ = git.PlainOpen(dir)
+	for _, c := range commits {
+		if _, err := repo.CommitObject(plumbing.NewHash(c)); err != nil {
+			t.Errorf("commit %s lost: %v", c, err)
+		}
+	}
+}
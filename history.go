@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxScriptOutput is how much of a script's output a transaction keeps. The
+// end of the output is kept since that is where errors show up.
+const maxScriptOutput = 64 * 1024
+
+// transaction records one mutating command and what it did to each package.
+type transaction struct {
+	ID       int          `json:"id"`
+	Action   string       `json:"action"`
+	User     string       `json:"user"`
+	SudoUser string       `json:"sudo_user,omitempty"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Packages []*txPackage `json:"packages"`
+	Error    string       `json:"error,omitempty"`
+	UndoOf   int          `json:"undo_of,omitempty"`
+	Hooks    []scriptRun  `json:"hooks,omitempty"`
+}
+
+type txPackage struct {
+	Name      string      `json:"name"`
+	URL       string      `json:"url,omitempty"`
+	OldCommit string      `json:"old_commit,omitempty"`
+	NewCommit string      `json:"new_commit,omitempty"`
+	Scripts   []scriptRun `json:"scripts,omitempty"`
+
+	files []string // checkout contents, for matching system hooks
+}
+
+type scriptRun struct {
+	Script   string `json:"script"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output,omitempty"`
+}
+
+func historyDir() string {
+	return filepath.Join(cfg.StateDir, "history")
+}
+
+// begin starts recording a transaction on m. Every package touched until
+// commit is added to it.
+func (m *model) begin(action string) {
+	tx := &transaction{Action: action, Started: time.Now()}
+	if u, err := user.Current(); err == nil {
+		tx.User = u.Username
+	}
+	tx.SudoUser = os.Getenv("SUDO_USER")
+	m.tx = tx
+}
+
+// commit stores the running transaction. Failing to write history never
+// fails the command itself.
+func (m *model) commit(err error) {
+	tx := m.tx
+	m.tx = nil
+	if tx == nil || len(tx.Packages) == 0 {
+		return
+	}
+	m.runSystemHooks(tx)
+	tx.Finished = time.Now()
+	if err != nil {
+		tx.Error = err.Error()
+	}
+	if err := saveTransaction(tx); err != nil {
+		slog.Warn("Could not save transaction", "action", tx.Action, "err", err)
+		return
+	}
+	slog.Info("Transaction recorded", "id", tx.ID, "action", tx.Action)
+}
+
+// txPackage returns the record for a package in the running transaction.
+// Without a transaction the record is simply discarded.
+func (m *model) txPackage(pakiet string) *txPackage {
+	if m.tx == nil {
+		return &txPackage{Name: pakiet}
+	}
+	for _, p := range m.tx.Packages {
+		if p.Name == pakiet {
+			return p
+		}
+	}
+	p := &txPackage{Name: pakiet}
+	m.tx.Packages = append(m.tx.Packages, p)
+	return p
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+// runScript runs a package script and records its exit code and output in
+// the running transaction.
+func (m *model) runScript(pakiet, script string) error {
+	cmd := m.scriptCommand(pakiet, script)
+	out := &tailBuffer{max: maxScriptOutput}
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, out)
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, out)
+	err := cmd.Run()
+	p := m.txPackage(pakiet)
+	p.Scripts = append(p.Scripts, scriptRun{Script: filepath.Base(script), ExitCode: exitCode(err), Output: string(out.buf)})
+	return err
+}
+
+// exitCode is the exit status a script run ended with, -1 if it could not
+// be started at all.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func saveTransaction(tx *transaction) error {
+	dir := historyDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	txs, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	tx.ID = 1
+	if len(txs) > 0 {
+		tx.ID = txs[len(txs)-1].ID + 1
+	}
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return err
+	}
+	// O_EXCL so two concurrent lcr runs cannot claim the same id.
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%d.json", tx.ID)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// loadHistory returns all transactions, oldest first.
+func loadHistory() ([]*transaction, error) {
+	entries, err := os.ReadDir(historyDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var txs []*transaction
+	for _, e := range entries {
+		if _, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json")); err != nil || e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(historyDir(), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tx := &transaction{}
+		if err := json.Unmarshal(data, tx); err != nil {
+			slog.Warn("Skipping unreadable transaction", "file", e.Name(), "err", err)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+	return txs, nil
+}
+
+func loadTransaction(id int) (*transaction, error) {
+	txs, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		if tx.ID == id {
+			return tx, nil
+		}
+	}
+	return nil, fmt.Errorf("no transaction with id %d", id)
+}
+
+// rollback puts a package back the way it was before p was recorded: it is
+// removed if it was newly installed, reinstalled if it was removed, and
+// checked out at its old commit otherwise.
+func (m *model) rollback(p *txPackage) error {
+	switch {
+	case p.OldCommit == p.NewCommit:
+		return nil
+	case p.OldCommit == "":
+		return m.remove(p.Name)
+	case p.NewCommit == "":
+		if p.URL == "" {
+			return fmt.Errorf("%s: transaction does not record where it came from", p.Name)
+		}
+		return m.installURL(p.Name, p.URL, p.OldCommit)
+	default:
+		return m.checkoutCommit(p.Name, p.OldCommit)
+	}
+}
+
+// undo reverts a transaction, last package first, as a new transaction.
+func (m *model) undo(id int) error {
+	tx, err := loadTransaction(id)
+	if err != nil {
+		return err
+	}
+	slog.Info("Undoing transaction", "id", id, "action", tx.Action)
+	m.begin("undo")
+	m.tx.UndoOf = id
+	var errs []error
+	for i := len(tx.Packages) - 1; i >= 0; i-- {
+		if err := m.rollback(tx.Packages[i]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tx.Packages[i].Name, err))
+		}
+	}
+	err = errors.Join(errs...)
+	m.commit(err)
+	return err
+}
+
+// planUndo prints what undoing a transaction would do to each package.
+func planUndo(id int) error {
+	tx, err := loadTransaction(id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Would undo transaction %d (%s):\n", tx.ID, tx.Action)
+	for i := len(tx.Packages) - 1; i >= 0; i-- {
+		p := tx.Packages[i]
+		switch {
+		case p.OldCommit == p.NewCommit:
+			continue
+		case p.OldCommit == "":
+			fmt.Printf("  remove %s\n", p.Name)
+		case p.NewCommit == "":
+			fmt.Printf("  reinstall %s at %s\n", p.Name, shortCommit(p.OldCommit))
+		default:
+			fmt.Printf("  move %s from %s back to %s\n", p.Name, shortCommit(p.NewCommit), shortCommit(p.OldCommit))
+		}
+	}
+	return nil
+}
+
+func (tx *transaction) packageNames() string {
+	names := make([]string, len(tx.Packages))
+	for i, p := range tx.Packages {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func (tx *transaction) status() string {
+	if tx.Error != "" {
+		return "failed"
+	}
+	return "ok"
+}
+
+func printHistory(w io.Writer, txs []*transaction) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDATE\tUSER\tACTION\tSTATUS\tPACKAGES")
+	for _, tx := range txs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			tx.ID, tx.Started.Format("2006-01-02 15:04"), tx.User, tx.Action, tx.status(), tx.packageNames())
+	}
+	tw.Flush()
+}
+
+func printTransaction(w io.Writer, tx *transaction) {
+	fmt.Fprintf(w, "Transaction %d: %s (%s)\n", tx.ID, tx.Action, tx.status())
+	fmt.Fprintf(w, "User:      %s", tx.User)
+	if tx.SudoUser != "" {
+		fmt.Fprintf(w, " (sudo from %s)", tx.SudoUser)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Started:   %s\n", tx.Started.Format(time.DateTime))
+	fmt.Fprintf(w, "Finished:  %s\n", tx.Finished.Format(time.DateTime))
+	if tx.UndoOf != 0 {
+		fmt.Fprintf(w, "Undoes:    %d\n", tx.UndoOf)
+	}
+	if tx.Error != "" {
+		fmt.Fprintf(w, "Error:     %s\n", tx.Error)
+	}
+	for _, p := range tx.Packages {
+		fmt.Fprintf(w, "\n%s: %s -> %s\n", p.Name, commitOrNone(p.OldCommit), commitOrNone(p.NewCommit))
+		if p.URL != "" {
+			fmt.Fprintf(w, "  source: %s\n", p.URL)
+		}
+		for _, s := range p.Scripts {
+			fmt.Fprintf(w, "  %s exited with %d\n", s.Script, s.ExitCode)
+			printOutput(w, s.Output)
+		}
+	}
+	if len(tx.Hooks) > 0 {
+		fmt.Fprintln(w, "\nSystem hooks:")
+		for _, s := range tx.Hooks {
+			fmt.Fprintf(w, "  %s exited with %d\n", s.Script, s.ExitCode)
+			printOutput(w, s.Output)
+		}
+	}
+}
+
+func printOutput(w io.Writer, output string) {
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "    | %s\n", line)
+		}
+	}
+}
+
+func commitOrNone(commit string) string {
+	if commit == "" {
+		return "(none)"
+	}
+	return shortCommit(commit)
+}
+
+// runHistoryCommand implements `lcr history [show <id> | undo <id>]`.
+func runHistoryCommand(args []string) error {
+	if len(args) == 0 {
+		txs, err := loadHistory()
+		if err != nil {
+			return err
+		}
+		printHistory(os.Stdout, txs)
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: lcr history [show <id> | undo <id>]")
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid transaction id %q", args[1])
+	}
+	switch args[0] {
+	case "show":
+		tx, err := loadTransaction(id)
+		if err != nil {
+			return err
+		}
+		printTransaction(os.Stdout, tx)
+		return nil
+	case "undo":
+		m := &model{packages: make(map[string]*indexEntry)}
+		confirmOrExit(fmt.Sprintf("undo transaction %d", id), true, func() error { return planUndo(id) })
+		if err := m.undo(id); err != nil {
+			return err
+		}
+		fmt.Printf("Transaction %d undone.\n", id)
+		return nil
+	}
+	return fmt.Errorf("unknown history command %q", args[0])
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Package hooks are optional scripts in lcr-build-files next to unpack.sh.
+// A failing pre-* hook stops the operation; a failing post-* hook only
+// warns, since the package has already changed by then.
+const (
+	hookPreInstall  = "pre-install.sh"
+	hookPostInstall = "post-install.sh"
+	hookPreRemove   = "pre-remove.sh"
+	hookPostUpgrade = "post-upgrade.sh"
+)
+
+// runPackageHook runs a package hook if the package has one.
+func (m *model) runPackageHook(pakiet, hook string) error {
+	script := filepath.Join(packageDir(pakiet), "lcr-build-files", hook)
+	if _, err := os.Stat(script); err != nil {
+		return nil
+	}
+	slog.Info("Running package hook", "package", pakiet, "hook", hook)
+	if err := m.checkScript(pakiet, script); err != nil {
+		return err
+	}
+	return m.runScript(pakiet, script)
+}
+
+// systemHook is a *.hook file in cfg.HooksDir, for example:
+//
+//	on = ["install", "update"]
+//	paths = ["*.desktop"]
+//	run = "update-desktop-database -q"
+//
+// It runs once after a transaction in which a package matching packages or
+// paths was changed by one of the actions in on. Empty lists match
+// everything. paths are matched against the files in the package checkout;
+// a pattern without a slash matches the file name alone.
+type systemHook struct {
+	Name     string   `toml:"-"`
+	On       []string `toml:"on"`
+	Packages []string `toml:"packages"`
+	Paths    []string `toml:"paths"`
+	Run      string   `toml:"run"`
+}
+
+func loadSystemHooks() ([]*systemHook, error) {
+	files, err := filepath.Glob(filepath.Join(cfg.HooksDir, "*.hook"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var hooks []*systemHook
+	for _, file := range files {
+		h := &systemHook{Name: strings.TrimSuffix(filepath.Base(file), ".hook")}
+		if _, err := toml.DecodeFile(file, h); err != nil {
+			slog.Warn("Skipping unreadable hook", "file", file, "err", err)
+			continue
+		}
+		if h.Run == "" {
+			slog.Warn("Skipping hook without run", "file", file)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// action is what a transaction did to a package, judged by its commits.
+func (p *txPackage) action() string {
+	switch {
+	case p.OldCommit == p.NewCommit:
+		return ""
+	case p.OldCommit == "":
+		return "install"
+	case p.NewCommit == "":
+		return "remove"
+	}
+	return "update"
+}
+
+func (h *systemHook) matches(p *txPackage) bool {
+	action := p.action()
+	if action == "" || len(h.On) > 0 && !slices.Contains(h.On, action) {
+		return false
+	}
+	if len(h.Packages) == 0 && len(h.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range h.Packages {
+		if ok, _ := path.Match(pattern, p.Name); ok {
+			return true
+		}
+	}
+	for _, file := range p.checkoutFiles() {
+		for _, pattern := range h.Paths {
+			name := file
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(file)
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkoutFiles lists the files of a package checkout, relative to it and
+// without .git, followed by the files its manifest says it installs. remove
+// fills p.files in before the checkout is deleted.
+func (p *txPackage) checkoutFiles() []string {
+	if p.files != nil {
+		return p.files
+	}
+	dir := packageDir(p.Name)
+	p.files = []string{}
+	filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(dir, file)
+			p.files = append(p.files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if mf, err := loadManifest(dir); err == nil && mf != nil {
+		p.files = append(p.files, mf.Files...)
+		for _, e := range mf.Install {
+			p.files = append(p.files, e.Dest)
+		}
+	}
+	return p.files
+}
+
+// runSystemHooks runs every system hook that matches a package changed by
+// tx, once each, and records the results in tx.
+func (m *model) runSystemHooks(tx *transaction) {
+	hooks, err := loadSystemHooks()
+	if err != nil {
+		slog.Warn("Could not read hooks", "dir", cfg.HooksDir, "err", err)
+		return
+	}
+	for _, h := range hooks {
+		var names []string
+		for _, p := range tx.Packages {
+			if h.matches(p) {
+				names = append(names, p.Name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		slog.Info("Running system hook", "hook", h.Name, "packages", names)
+		cmd := hookCommand(h.Run)
+		cmd.Env = append(baseScriptEnv(),
+			"LCR_ACTION="+tx.Action,
+			"LCR_HOOK_PACKAGES="+strings.Join(names, " "),
+		)
+		out := &tailBuffer{max: maxScriptOutput}
+		cmd.Stdout = io.MultiWriter(m.out(), out)
+		cmd.Stderr = io.MultiWriter(m.errOut(), out)
+		err := cmd.Run()
+		if err != nil {
+			slog.Warn("System hook failed", "hook", h.Name, "err", err)
+		}
+		tx.Hooks = append(tx.Hooks, scriptRun{Script: h.Name + ".hook", ExitCode: exitCode(err), Output: string(out.buf)})
+	}
+}
+
+// hookCommand runs a hook's shell command, inside the alternate root when
+// chroot is enabled.
+func hookCommand(command string) *exec.Cmd {
+	if cfg.Root != "" && cfg.Chroot {
+		return exec.Command("chroot", cfg.Root, "/bin/sh", "-c", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
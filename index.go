@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// `lcr index` maintains a repo-list.lcr file such as library/repo-list.lcr
+// in this repository. Unlike parseRepoList, which skips what it cannot use,
+// it treats every problem in the file as an error.
+
+const defaultIndexFile = "library/repo-list.lcr"
+
+// indexFileEntry is an entry together with the comment lines above it.
+type indexFileEntry struct {
+	indexEntry
+	comments []string
+	line     int
+}
+
+type indexFile struct {
+	// header holds comments before the first entry, which stay at the top.
+	header  []string
+	entries []*indexFileEntry
+	// trailer holds comments after the last entry.
+	trailer []string
+}
+
+// readIndexFile parses an index file, returning its entries and a
+// "path:line: message" string for every problem found.
+func readIndexFile(path string) (*indexFile, []string, error) {
+	idx := &indexFile{}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var problems []string
+	problem := func(n int, format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("%s:%d: ", path, n)+fmt.Sprintf(format, args...))
+	}
+	seen := make(map[string]int)
+	var last *indexFileEntry
+	var comments []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			comments = append(comments, line)
+			continue
+		case raw[0] == ' ' || raw[0] == '\t':
+			if last == nil {
+				problem(n, "metadata without an entry")
+				continue
+			}
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				problem(n, "expected \"key: value\"")
+				continue
+			}
+			if err := last.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				problem(n, "%v", err)
+			}
+			continue
+		}
+		name, url, ok := strings.Cut(line, " -> ")
+		if !ok {
+			problem(n, "expected \"name -> url\"")
+			last = nil
+			continue
+		}
+		e := &indexFileEntry{
+			indexEntry: indexEntry{Name: strings.TrimSpace(name), URL: strings.TrimSpace(url)},
+			comments:   comments,
+			line:       n,
+		}
+		if len(idx.entries) == 0 {
+			idx.header, e.comments = comments, nil
+		}
+		comments = nil
+		if err := validatePackageName(e.Name); err != nil {
+			problem(n, "%v", err)
+		}
+		if err := validateRepoURL(e.URL); err != nil {
+			problem(n, "%v", err)
+		}
+		if len(idx.entries) > 0 {
+			checkArchiveEntry(idx.entries[len(idx.entries)-1], problem)
+		}
+		if first, dup := seen[e.Name]; dup {
+			problem(n, "%s is already listed on line %d", e.Name, first)
+		} else {
+			seen[e.Name] = n
+		}
+		idx.entries = append(idx.entries, e)
+		last = e
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(idx.entries) > 0 {
+		checkArchiveEntry(idx.entries[len(idx.entries)-1], problem)
+	}
+	idx.trailer = comments
+	return idx, problems, nil
+}
+
+// checkArchive reports an archive entry without a checksum once all its
+// metadata has been read.
+func checkArchiveEntry(e *indexFileEntry, problem func(int, string, ...any)) {
+	if isArchiveURL(e.URL) && e.SHA256 == "" {
+		problem(e.line, "archive %s needs a sha256", e.Name)
+	}
+}
+
+func (idx *indexFile) find(name string) int {
+	return slices.IndexFunc(idx.entries, func(e *indexFileEntry) bool { return e.Name == name })
+}
+
+func (idx *indexFile) sort() {
+	slices.SortStableFunc(idx.entries, func(a, b *indexFileEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+func (idx *indexFile) sorted() bool {
+	return slices.IsSortedFunc(idx.entries, func(a, b *indexFileEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// format renders the index in its canonical form: entries sorted by name,
+// metadata indented by four spaces in a fixed order.
+func (idx *indexFile) format() string {
+	var b strings.Builder
+	for _, c := range idx.header {
+		b.WriteString(c + "\n")
+	}
+	for _, e := range idx.entries {
+		for _, c := range e.comments {
+			b.WriteString(c + "\n")
+		}
+		fmt.Fprintf(&b, "%s -> %s\n", e.Name, e.URL)
+		if e.Description != "" {
+			fmt.Fprintf(&b, "    description: %s\n", e.Description)
+		}
+		if len(e.Tags) > 0 {
+			fmt.Fprintf(&b, "    tags: %s\n", strings.Join(e.Tags, ", "))
+		}
+		if e.Version != "" {
+			fmt.Fprintf(&b, "    version: %s\n", e.Version)
+		}
+		if e.SHA256 != "" {
+			fmt.Fprintf(&b, "    sha256: %s\n", e.SHA256)
+		}
+	}
+	for _, c := range idx.trailer {
+		b.WriteString(c + "\n")
+	}
+	return b.String()
+}
+
+func (idx *indexFile) write(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(idx.format()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// verifyIndexEntry clones an entry into memory and checks that it is a
+// package whose manifest, if any, agrees with the index. It returns the
+// manifest so callers can copy metadata from it.
+func verifyIndexEntry(e *indexEntry) (*manifest, error) {
+	var fs billy.Filesystem
+	if isArchiveURL(e.URL) {
+		dir, err := os.MkdirTemp("", "lcr-index-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if err := unpackArchive(archiveSourceFor(e), filepath.Join(dir, e.Name)); err != nil {
+			return nil, err
+		}
+		fs = osfs.New(filepath.Join(dir, e.Name))
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+		defer cancel()
+		fs = memfs.New()
+		_, err := git.CloneContext(ctx, memory.NewStorage(), fs, &git.CloneOptions{URL: e.URL, Depth: 1})
+		if err != nil {
+			return nil, fmt.Errorf("clone %s: %w", e.URL, err)
+		}
+	}
+	if info, err := fs.Stat("lcr-build-files"); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s has no lcr-build-files directory", e.URL)
+	}
+	mf, err := readManifest(fs)
+	if err != nil || mf == nil {
+		return nil, err
+	}
+	if mf.Name != "" && mf.Name != e.Name {
+		return nil, fmt.Errorf("manifest names the package %q", mf.Name)
+	}
+	if errs := mf.schemaErrors(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return mf, nil
+}
+
+// checkIndex returns every problem with the index file at path, cloning
+// each entry unless offline.
+func checkIndex(path string, offline bool) ([]string, error) {
+	idx, problems, err := readIndexFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !idx.sorted() {
+		problems = append(problems, fmt.Sprintf("%s: entries are not sorted by name; run lcr index fmt", path))
+	}
+	if data, err := os.ReadFile(path); err == nil && len(problems) == 0 && string(data) != idx.format() {
+		problems = append(problems, fmt.Sprintf("%s: not formatted; run lcr index fmt", path))
+	}
+	if offline {
+		return problems, nil
+	}
+	results := make([]error, len(idx.entries))
+	sem := make(chan struct{}, max(cfg.Parallelism, 1))
+	var wg sync.WaitGroup
+	for i, e := range idx.entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, e *indexFileEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, results[i] = verifyIndexEntry(&e.indexEntry)
+		}(i, e)
+	}
+	wg.Wait()
+	for i, err := range results {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s: %v", path, idx.entries[i].line, idx.entries[i].Name, err))
+		}
+	}
+	return problems, nil
+}
+
+// runIndexCommand implements `lcr index [--file path] add|remove|check|fmt`.
+func runIndexCommand(args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	path := fs.String("file", defaultIndexFile, "Index file to work on")
+	description := fs.String("description", "", "Description for add, instead of the one in the manifest")
+	tags := fs.String("tags", "", "Comma-separated tags for add")
+	sum := fs.String("sha256", "", "Checksum for add, required for release archives")
+	version := fs.String("version", "", "Version for add, for release archives")
+	offline := fs.Bool("offline", false, "For check, do not clone the listed repositories")
+	fill := fs.Bool("fill", false, "For fmt, refresh descriptions from each package's manifest")
+	fs.Parse(args)
+	usage := fmt.Errorf("usage: lcr index [--file path] add <name> <url> | remove <name> | check | fmt")
+	if fs.NArg() == 0 {
+		return usage
+	}
+
+	// Flags may also follow the subcommand.
+	cmd := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	rest := fs.Args()
+	switch cmd {
+	case "add":
+		if len(rest) != 2 {
+			return usage
+		}
+		idx, problems, err := readIndexFile(*path)
+		if errors.Is(err, os.ErrNotExist) {
+			idx, err = &indexFile{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("fix %s first:\n%s", *path, strings.Join(problems, "\n"))
+		}
+		e := &indexFileEntry{indexEntry: indexEntry{Name: rest[0], URL: rest[1]}}
+		if *sum != "" {
+			if err := e.set("sha256", *sum); err != nil {
+				return err
+			}
+		} else if isArchiveURL(e.URL) {
+			return fmt.Errorf("%s is a release archive; pass its --sha256", e.URL)
+		}
+		e.Version = *version
+		if err := validatePackageName(e.Name); err != nil {
+			return err
+		}
+		if err := validateRepoURL(e.URL); err != nil {
+			return err
+		}
+		if idx.find(e.Name) >= 0 {
+			return fmt.Errorf("%s is already listed in %s", e.Name, *path)
+		}
+		mf, err := verifyIndexEntry(&e.indexEntry)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Name, err)
+		}
+		e.Description = *description
+		if e.Description == "" && mf != nil {
+			e.Description = mf.Description
+		}
+		if *tags != "" {
+			e.set("tags", *tags)
+		}
+		idx.entries = append(idx.entries, e)
+		idx.sort()
+		if err := idx.write(*path); err != nil {
+			return err
+		}
+		fmt.Printf("Added %s to %s.\n", e.Name, *path)
+	case "remove":
+		if len(rest) != 1 {
+			return usage
+		}
+		idx, _, err := readIndexFile(*path)
+		if err != nil {
+			return err
+		}
+		i := idx.find(rest[0])
+		if i < 0 {
+			return fmt.Errorf("%s is not listed in %s", rest[0], *path)
+		}
+		idx.entries = slices.Delete(idx.entries, i, i+1)
+		if err := idx.write(*path); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s from %s.\n", rest[0], *path)
+	case "check":
+		problems, err := checkIndex(*path, *offline)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%s has %d problems", *path, len(problems))
+		}
+		fmt.Printf("%s is fine.\n", *path)
+	case "fmt":
+		idx, problems, err := readIndexFile(*path)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("fix %s first:\n%s", *path, strings.Join(problems, "\n"))
+		}
+		if *fill {
+			for _, e := range idx.entries {
+				mf, err := verifyIndexEntry(&e.indexEntry)
+				if err != nil {
+					return fmt.Errorf("%s: %w", e.Name, err)
+				}
+				if mf != nil && mf.Description != "" {
+					e.Description = mf.Description
+				}
+			}
+		}
+		idx.sort()
+		return idx.write(*path)
+	default:
+		return usage
+	}
+	return nil
+}
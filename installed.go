@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// installedPackage is what lcr remembers about a package it installed.
+type installedPackage struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Commit      string    `json:"commit"`
+	InstalledAt time.Time `json:"installed_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Copied from lcr.toml, if the package has one.
+	Version   string   `json:"version,omitempty"`
+	Provides  []string `json:"provides,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+
+	// Files installed from [[install]] entries, as seen inside the root.
+	Files []string `json:"files,omitempty"`
+
+	// Local is set for packages installed with --from rather than from the
+	// index.
+	Local bool `json:"local,omitempty"`
+
+	// Dependency is set for packages installed only because another
+	// package depends on them. `lcr autoremove` removes them once nothing
+	// needs them any more.
+	Dependency bool `json:"dependency,omitempty"`
+
+	// Upgradable is filled in by checkUpgradable and never stored.
+	Upgradable bool `json:"-"`
+}
+
+type installedDB struct {
+	Packages map[string]*installedPackage `json:"packages"`
+}
+
+func installedDBPath() string {
+	return filepath.Join(cfg.StateDir, "installed.json")
+}
+
+func loadInstalledDB() (*installedDB, error) {
+	db := &installedDB{Packages: make(map[string]*installedPackage)}
+	data, err := os.ReadFile(installedDBPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", installedDBPath(), err)
+	}
+	if db.Packages == nil {
+		db.Packages = make(map[string]*installedPackage)
+	}
+	return db, nil
+}
+
+func (db *installedDB) save() error {
+	path := installedDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// recordInstalled stores the current commit of an installed package.
+func recordInstalled(pakiet, url string) error {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return err
+	}
+	commit, err := headCommit(packageDir(pakiet))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	p, ok := db.Packages[pakiet]
+	if !ok {
+		p = &installedPackage{Name: pakiet, InstalledAt: now}
+		db.Packages[pakiet] = p
+	}
+	if url != "" {
+		p.URL = url
+	}
+	p.Commit = commit
+	p.UpdatedAt = now
+	p.Version, p.Provides, p.Conflicts = "", nil, nil
+	if mf, err := loadManifest(packageDir(pakiet)); err != nil {
+		slog.Warn("Could not read manifest", "package", pakiet, "err", err)
+	} else if mf != nil {
+		p.Version, p.Provides, p.Conflicts = mf.Version, mf.Provides, mf.Conflicts
+	}
+	if src, err := readArchiveMarker(packageDir(pakiet)); err == nil && src.Version != "" {
+		p.Version = src.Version
+	}
+	return db.save()
+}
+
+// recordFiles stores the files a package installed natively.
+func recordFiles(pakiet string, files []string) error {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return err
+	}
+	p, ok := db.Packages[pakiet]
+	if !ok {
+		p = &installedPackage{Name: pakiet, InstalledAt: time.Now()}
+		db.Packages[pakiet] = p
+	}
+	p.Files = files
+	return db.save()
+}
+
+// markLocal records that a package was installed with --from.
+func markLocal(pakiet string) error {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return err
+	}
+	p, ok := db.Packages[pakiet]
+	if !ok {
+		return fmt.Errorf("%s is not recorded as installed", pakiet)
+	}
+	p.Local = true
+	return db.save()
+}
+
+// markDependency records that a package was installed to satisfy another
+// package's depends.
+func markDependency(pakiet string) error {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return err
+	}
+	p, ok := db.Packages[pakiet]
+	if !ok {
+		return fmt.Errorf("%s is not recorded as installed", pakiet)
+	}
+	p.Dependency = true
+	return db.save()
+}
+
+func forgetInstalled(pakiet string) error {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return err
+	}
+	delete(db.Packages, pakiet)
+	return db.save()
+}
+
+// listInstalled returns every package under the install root sorted by name.
+// Packages installed before the database existed are filled in from their
+// git checkout. Leftovers of interrupted operations are not packages.
+func listInstalled() ([]*installedPackage, error) {
+	db, err := loadInstalledDB()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(cfg.InstallRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pkgs []*installedPackage
+	for _, e := range entries {
+		if !e.IsDir() || isLeftover(e.Name()) || validatePackageName(e.Name()) != nil {
+			continue
+		}
+		p, ok := db.Packages[e.Name()]
+		if !ok {
+			p = &installedPackage{Name: e.Name()}
+			dir := packageDir(e.Name())
+			if info, err := e.Info(); err == nil {
+				p.InstalledAt = info.ModTime()
+				p.UpdatedAt = info.ModTime()
+			}
+			if commit, err := headCommit(dir); err == nil {
+				p.Commit = commit
+			}
+			if url, err := originURL(dir); err == nil {
+				p.URL = url
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// unneededPackages returns the packages installed as dependencies that no
+// other remaining package depends on, by name or through provides. A
+// package only needed by unneeded ones is unneeded too, and comes after
+// them, so removing in order never leaves a dependency without its user.
+func unneededPackages() ([]string, error) {
+	pkgs, err := listInstalled()
+	if err != nil {
+		return nil, err
+	}
+	depends := make(map[string][]string)
+	for _, p := range pkgs {
+		mf, err := loadManifest(packageDir(p.Name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		if mf != nil {
+			depends[p.Name] = mf.Depends
+		}
+	}
+	var unneeded []string
+	for {
+		needed := make(map[string]bool)
+		for _, p := range pkgs {
+			for _, dep := range depends[p.Name] {
+				needed[dep] = true
+			}
+		}
+		var keep []*installedPackage
+		found := false
+		for _, p := range pkgs {
+			if p.Dependency && !needed[p.Name] && !slices.ContainsFunc(p.Provides, func(name string) bool { return needed[name] }) {
+				unneeded = append(unneeded, p.Name)
+				found = true
+				continue
+			}
+			keep = append(keep, p)
+		}
+		if !found {
+			return unneeded, nil
+		}
+		pkgs = keep
+	}
+}
+
+// installedSet returns the names of installed packages. Errors are logged
+// and treated as nothing installed, since callers only use it for display.
+func installedSet() map[string]bool {
+	set := make(map[string]bool)
+	pkgs, err := listInstalled()
+	if err != nil {
+		slog.Warn("Could not list installed packages", "err", err)
+	}
+	for _, p := range pkgs {
+		set[p.Name] = true
+	}
+	return set
+}
+
+// headCommit is the commit a package checkout is at, or the checksum of
+// the archive it was extracted from.
+func headCommit(dir string) (string, error) {
+	if src, err := readArchiveMarker(dir); err == nil {
+		return src.SHA256, nil
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+func originURL(dir string) (string, error) {
+	if src, err := readArchiveMarker(dir); err == nil {
+		return src.URL, nil
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return "", fmt.Errorf("origin of %s has no URL", dir)
+	}
+	return urls[0], nil
+}
+
+// checkUpgradable asks the origin remote whether the checked out branch has
+// moved on since the package was installed. An archive package is
+// upgradable when the index lists a different archive for it.
+func checkUpgradable(p *installedPackage, index map[string]*indexEntry) error {
+	if isArchivePackage(packageDir(p.Name)) {
+		if e, ok := index[p.Name]; ok {
+			p.Upgradable = e.SHA256 != p.Commit
+		}
+		return nil
+	}
+	repo, err := git.PlainOpen(packageDir(p.Name))
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if ref.Name() == head.Name() {
+			p.Upgradable = ref.Hash() != head.Hash()
+			return nil
+		}
+	}
+	slog.Debug("No remote ref matches HEAD", "package", p.Name, "ref", head.Name())
+	return nil
+}
+
+// checkUpgrades runs checkUpgradable for all packages, cfg.Parallelism at a
+// time.
+func checkUpgrades(pkgs []*installedPackage, index map[string]*indexEntry) {
+	sem := make(chan struct{}, max(cfg.Parallelism, 1))
+	var wg sync.WaitGroup
+	for _, p := range pkgs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p *installedPackage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := checkUpgradable(p, index); err != nil {
+				slog.Warn("Could not check for upgrades", "package", p.Name, "err", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func (p *installedPackage) info() string {
+	upgradable := "no"
+	if p.Upgradable {
+		upgradable = "yes"
+	}
+	version := p.Version
+	if version == "" {
+		version = "unknown"
+	}
+	source := p.URL
+	if p.Local {
+		source += " (local)"
+	}
+	return fmt.Sprintf(`Name:        %s
+Version:     %s
+Source:      %s
+Commit:      %s
+Installed:   %s
+Updated:     %s
+Upgradable:  %s
+Path:        %s`,
+		p.Name, version, source, p.Commit,
+		p.InstalledAt.Format(time.DateTime), p.UpdatedAt.Format(time.DateTime),
+		upgradable, packageDir(p.Name))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestListInstalledSkipsLeftovers(t *testing.T) {
+	useTestConfig(t)
+	for _, name := range []string{"pkg", ".extract-123", ".old-pkg", ".full-pkg", "-bad"} {
+		if err := os.MkdirAll(filepath.Join(cfg.InstallRoot, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkgs, err := listInstalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "pkg" {
+		var names []string
+		for _, p := range pkgs {
+			names = append(names, p.Name)
+		}
+		t.Fatalf("listInstalled() = %v, want [pkg]", names)
+	}
+}
+
+func TestUnneededPackages(t *testing.T) {
+	useTestConfig(t)
+	m := &model{packages: make(map[string]*indexEntry)}
+	for name, manifest := range map[string]string{
+		"app":  `depends = ["lib"]`,
+		"lib":  `depends = ["zlib"]`,
+		"zlib": `provides = ["compression"]`,
+		"tool": `depends = ["compression"]`,
+	} {
+		u := newUpstream(t)
+		u.commit("lcr-build-files/lcr.toml", manifest+"\n")
+		m.packages[name] = &indexEntry{Name: name, URL: u.url()}
+	}
+	check := func(want ...string) {
+		t.Helper()
+		got, err := unneededPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("unneededPackages() = %v, want %v", got, want)
+		}
+	}
+	if err := m.install("app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.install("tool"); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// zlib still provides what tool depends on.
+	if err := m.remove("app"); err != nil {
+		t.Fatal(err)
+	}
+	check("lib")
+	if err := m.autoremove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(packageDir("lib")); err == nil {
+		t.Fatal("lib survived autoremove")
+	}
+	check()
+
+	if err := m.remove("tool"); err != nil {
+		t.Fatal(err)
+	}
+	check("zlib")
+}
+
+func TestUnneededPackagesChain(t *testing.T) {
+	useTestConfig(t)
+	m := &model{packages: make(map[string]*indexEntry)}
+	for name, manifest := range map[string]string{
+		"app":  `depends = ["lib"]`,
+		"lib":  `depends = ["zlib"]`,
+		"zlib": ``,
+	} {
+		u := newUpstream(t)
+		u.commit("lcr-build-files/lcr.toml", manifest+"\n")
+		m.packages[name] = &indexEntry{Name: name, URL: u.url()}
+	}
+	if err := m.install("app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.remove("app"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := unneededPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lib", "zlib"}; !slices.Equal(got, want) {
+		t.Fatalf("unneededPackages() = %v, want %v", got, want)
+	}
+}
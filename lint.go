@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-git/go-git/v5"
+)
+
+// lintFinding is one problem lint found in a package repository.
+type lintFinding struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+type linter struct {
+	dir      string
+	findings []lintFinding
+}
+
+func (l *linter) report(severity, file string, line int, format string, args ...any) {
+	if rel, err := filepath.Rel(l.dir, file); err == nil && file != "" {
+		file = filepath.ToSlash(rel)
+	}
+	l.findings = append(l.findings, lintFinding{severity, file, line, fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) errorf(file string, line int, format string, args ...any) {
+	l.report("error", file, line, format, args...)
+}
+
+func (l *linter) warnf(file string, line int, format string, args ...any) {
+	l.report("warning", file, line, format, args...)
+}
+
+func (l *linter) count(severity string) int {
+	n := 0
+	for _, f := range l.findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+var (
+	// rmRecursiveRe matches rm with a recursive flag; its arguments are
+	// checked separately.
+	rmRecursiveRe = regexp.MustCompile(`(^|[;&|]\s*|sudo\s+)rm\s+((-[A-Za-z-]+\s+)*-[A-Za-z]*[rR][A-Za-z]*)\s+(.*)`)
+	pipeToShellRe = regexp.MustCompile(`\b(curl|wget)\b[^|#]*\|\s*(sudo\s+)?(ba|da|z|k)?sh\b`)
+	shLineRe      = regexp.MustCompile(`:\s*(?:line\s+)?(\d+):`)
+	installCmdRe  = regexp.MustCompile(`^(sudo\s+)?(install|cp|ln|mv)\s`)
+)
+
+// lintPackage checks the package repository checked out in dir.
+func lintPackage(dir string) []lintFinding {
+	l := &linter{dir: dir}
+	build := filepath.Join(dir, "lcr-build-files")
+	if info, err := os.Stat(build); err != nil || !info.IsDir() {
+		l.errorf("", 0, "lcr-build-files directory is missing")
+		return l.findings
+	}
+
+	mf := l.lintManifest()
+	native := mf != nil && len(mf.Install) > 0
+
+	unpack := packageScript(dir, "unpack")
+	remove := packageScript(dir, "remove")
+	var scripts []string
+	if _, err := os.Stat(unpack); err == nil {
+		scripts = append(scripts, unpack)
+	} else if !native {
+		l.errorf(unpack, 0, "unpack script is missing; installing the package would fail")
+	}
+	if _, err := os.Stat(remove); err == nil {
+		scripts = append(scripts, remove)
+	} else if !native {
+		l.warnf(remove, 0, "no remove script; whatever unpack installs is left behind on remove")
+	}
+	for _, hook := range []string{hookPreInstall, hookPostInstall, hookPreRemove, hookPostUpgrade} {
+		if script := filepath.Join(build, hook); slices.Contains(scripts, script) {
+			continue
+		} else if _, err := os.Stat(script); err == nil {
+			scripts = append(scripts, script)
+		}
+	}
+	for _, script := range scripts {
+		l.lintScript(script)
+	}
+	if !native && slices.Contains(scripts, unpack) && slices.Contains(scripts, remove) {
+		l.lintRemoveCoverage(unpack, remove)
+	}
+	return l.findings
+}
+
+// lintManifest checks lcr.toml, if there is one, and returns it.
+func (l *linter) lintManifest() *manifest {
+	file := filepath.Join(l.dir, manifestPath)
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+	mf := &manifest{}
+	md, err := toml.DecodeFile(file, mf)
+	if err != nil {
+		var perr toml.ParseError
+		line := 0
+		if errors.As(err, &perr) {
+			line = perr.Position.Line
+		}
+		l.errorf(file, line, "%v", err)
+		return nil
+	}
+	for _, key := range md.Undecoded() {
+		l.warnf(file, 0, "unknown key %s", key)
+	}
+	for _, err := range mf.schemaErrors() {
+		l.errorf(file, 0, "%v", err)
+	}
+	for _, e := range mf.Install {
+		if e.Source == "" || !filepath.IsLocal(e.Source) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(l.dir, e.Source)); err != nil {
+			l.errorf(file, 0, "install source %s does not exist", e.Source)
+		}
+	}
+	for _, a := range mf.Arch {
+		known := a == "any"
+		for goarch, names := range archNames {
+			known = known || a == goarch || slices.Contains(names, a)
+		}
+		if !known {
+			l.warnf(file, 0, "unknown architecture %q", a)
+		}
+	}
+	return mf
+}
+
+// lintScript checks a package script's permissions, shebang and syntax, and
+// looks for commands that are dangerous in an install script.
+func (l *linter) lintScript(script string) {
+	data, err := os.ReadFile(script)
+	if err != nil {
+		l.errorf(script, 0, "%v", err)
+		return
+	}
+	if info, err := os.Stat(script); err == nil && info.Mode().Perm()&0111 == 0 {
+		l.warnf(script, 0, "not executable; lcr has to chmod it, which leaves the checkout modified")
+	}
+	lines := strings.Split(string(data), "\n")
+	switch {
+	case !strings.HasPrefix(lines[0], "#!"):
+		l.warnf(script, 1, "no shebang; add #!/bin/sh")
+	case strings.Contains(lines[0], "bash") || strings.Contains(lines[0], "zsh"):
+		l.warnf(script, 1, "lcr runs package scripts with /bin/sh, not %s", strings.TrimPrefix(lines[0], "#!"))
+	}
+
+	out, err := exec.Command("/bin/sh", "-n", script).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		line := 0
+		if m := shLineRe.FindStringSubmatchIndex(msg); m != nil {
+			line, _ = strconv.Atoi(msg[m[2]:m[3]])
+			msg = strings.TrimSpace(msg[m[1]:])
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		l.errorf(script, line, "%s", msg)
+	}
+
+	for i, text := range lines {
+		text = strings.TrimSpace(text)
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if m := rmRecursiveRe.FindStringSubmatch(text); m != nil {
+			for _, arg := range strings.Fields(m[4]) {
+				if strings.HasPrefix(arg, "$") || arg == "/" || arg == "/*" || arg == "~" {
+					l.warnf(script, i+1, "rm %s with unquoted %s; an empty or odd value can delete the wrong files", m[2], arg)
+					break
+				}
+			}
+		}
+		if pipeToShellRe.MatchString(text) {
+			l.warnf(script, i+1, "pipes a download straight into a shell")
+		}
+	}
+}
+
+// lintRemoveCoverage warns about files unpack installs with install, cp, ln
+// or mv that remove never mentions. It only compares file names, so it is a
+// hint rather than a proof.
+func (l *linter) lintRemoveCoverage(unpack, remove string) {
+	data, err := os.ReadFile(unpack)
+	if err != nil {
+		return
+	}
+	removeData, err := os.ReadFile(remove)
+	if err != nil {
+		return
+	}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimSpace(text)
+		m := installCmdRe.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		fields := strings.Fields(text)
+		if m[2] == "install" && slices.ContainsFunc(fields, func(f string) bool {
+			return strings.HasPrefix(f, "-") && !strings.HasPrefix(f, "--") && strings.Contains(f, "d") && !strings.Contains(f, "D")
+		}) {
+			// install -d only creates directories.
+			continue
+		}
+		target := strings.Trim(fields[len(fields)-1], `"'`)
+		if strings.HasSuffix(target, "/") && len(fields) > 2 {
+			// Copied into a directory under its own name.
+			target = strings.Trim(fields[len(fields)-2], `"'`)
+		}
+		name := path.Base(target)
+		if strings.HasPrefix(target, "-") || strings.ContainsAny(name, "$`*") || name == "." || name == "/" {
+			continue
+		}
+		if !strings.Contains(string(removeData), name) {
+			l.warnf(unpack, i+1, "%s is installed here but never mentioned in %s", name, filepath.Base(remove))
+		}
+	}
+}
+
+// packageCheckout returns a directory holding the package at target: target
+// itself for a path, or a temporary shallow clone for a URL. cleanup removes
+// the clone.
+func packageCheckout(target string) (dir string, cleanup func(), err error) {
+	if !isRepoURL(target) {
+		return target, func() {}, nil
+	}
+	if err := validateRepoURL(target); err != nil {
+		return "", nil, err
+	}
+	tmp, err := os.MkdirTemp("", "lcr-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+	if _, err := git.PlainClone(tmp, false, &git.CloneOptions{URL: target, Depth: 1}); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("clone %s: %w", target, err)
+	}
+	return tmp, cleanup, nil
+}
+
+// isRepoURL tells a repository URL from a local path.
+func isRepoURL(arg string) bool {
+	return strings.Contains(arg, "://") || scpLikeRe.MatchString(arg)
+}
+
+// location is file:line, or . for the repository as a whole.
+func (f lintFinding) location() string {
+	loc := f.File
+	if loc == "" {
+		loc = "."
+	}
+	if f.Line > 0 {
+		loc += ":" + strconv.Itoa(f.Line)
+	}
+	return loc
+}
+
+func printLint(w io.Writer, findings []lintFinding) {
+	errs, warnings := 0, 0
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s: %s: %s\n", f.location(), f.Severity, f.Message)
+		if f.Severity == "error" {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	fmt.Fprintf(w, "%d errors, %d warnings\n", errs, warnings)
+}
+
+// runLintCommand implements `lcr lint [--json] [path|url]`. It fails when
+// there are errors, or warnings too with --strict.
+func runLintCommand(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print findings as JSON")
+	strict := fs.Bool("strict", false, "Fail on warnings too")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: lcr lint [--json] [--strict] [path|url]")
+	}
+	target := "."
+	if fs.NArg() == 1 {
+		target = fs.Arg(0)
+	}
+	dir, cleanup, err := packageCheckout(target)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	l := &linter{findings: lintPackage(dir)}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			Path     string        `json:"path"`
+			Errors   int           `json:"errors"`
+			Warnings int           `json:"warnings"`
+			Findings []lintFinding `json:"findings"`
+		}{target, l.count("error"), l.count("warning"), append([]lintFinding{}, l.findings...)})
+		if err != nil {
+			return err
+		}
+	} else {
+		printLint(os.Stdout, l.findings)
+	}
+	if l.count("error") > 0 || *strict && l.count("warning") > 0 {
+		return fmt.Errorf("%s did not pass lint", target)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// A local package is installed with `lcr install --from` instead of from the
+// index. When its source is a directory, lcr tracks that working tree: the
+// committed tip plus any uncommitted changes, so an author can edit a
+// script and run `lcr update` without committing or pushing.
+
+// isLocalDir tells whether a package URL is a working tree on this machine.
+func isLocalDir(url string) bool {
+	if !filepath.IsAbs(url) {
+		return false
+	}
+	info, err := os.Stat(url)
+	return err == nil && info.IsDir()
+}
+
+// installFrom installs an unlisted package from a directory or repository
+// URL. An empty name is taken from the manifest, or else from the last
+// element of the source.
+func (m *model) installFrom(source, name string) (string, error) {
+	if !isRepoURL(source) {
+		dir, err := filepath.Abs(source)
+		if err != nil {
+			return "", err
+		}
+		repo, err := git.PlainOpen(dir)
+		if err != nil {
+			return "", fmt.Errorf("%s is not a git repository: %w", source, err)
+		}
+		if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return "", fmt.Errorf("%s has no commits yet; commit your files first", source)
+		}
+		source = dir
+		if name == "" {
+			if mf, err := loadManifest(dir); err == nil && mf != nil {
+				name = mf.Name
+			}
+		}
+		// Asking for a directory by hand is consent enough to clone it.
+		defer func(allow bool) { cfg.AllowFileURLs = allow }(cfg.AllowFileURLs)
+		cfg.AllowFileURLs = true
+	}
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(filepath.ToSlash(strings.TrimSuffix(source, "/"))), ".git")
+	}
+	if err := validatePackageName(name); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(packageDir(name)); err == nil {
+		return "", fmt.Errorf("%s is already installed; remove it first", name)
+	}
+	slog.Info("Installing local package", "package", name, "source", source)
+	if err := m.installURL(name, source, ""); err != nil {
+		return name, err
+	}
+	return name, markLocal(name)
+}
+
+// pullLocal brings a local package to the tip of its source working tree.
+// Unlike a pull it follows amended and rebased commits, which are common
+// while a package is being written.
+func (m *model) pullLocal(repo *git.Repository, w *git.Worktree, src, dest string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitTimeout)
+	defer cancel()
+	err := repo.FetchContext(ctx, &git.FetchOptions{RemoteName: "origin", Force: true, Progress: m.gitProgress})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	head, err := headCommit(src)
+	if err != nil {
+		return err
+	}
+	if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(head), Mode: git.HardReset}); err != nil {
+		return err
+	}
+	return syncWorkingTree(src, dest)
+}
+
+// syncWorkingTree copies the uncommitted changes of the working tree src
+// over the package checkout dest.
+func syncWorkingTree(src, dest string) error {
+	repo, err := git.PlainOpen(src)
+	if err != nil {
+		return err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+	for file, s := range status {
+		if s.Worktree == git.Unmodified && s.Staging == git.Unmodified {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(file))
+		if s.Worktree == git.Deleted || s.Staging == git.Deleted {
+			os.Remove(target)
+			continue
+		}
+		slog.Debug("Copying uncommitted file", "file", file)
+		if err := installFile(filepath.Join(src, filepath.FromSlash(file)), target, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planInstallFrom prints what installFrom would do.
+func (m *model) planInstallFrom(source, name string) error {
+	commit := ""
+	var err error
+	if isRepoURL(source) {
+		if err := validateRepoURL(source); err != nil {
+			return err
+		}
+		commit, err = remoteHead(source)
+	} else {
+		commit, err = headCommit(source)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	if name == "" {
+		name = "(named by its manifest)"
+	}
+	out := m.out()
+	fmt.Fprintf(out, "Would install %s as a local package:\n", name)
+	fmt.Fprintf(out, "  clone %s at %s\n", source, shortCommit(commit))
+	if !isRepoURL(source) {
+		fmt.Fprintf(out, "  copy its uncommitted changes\n")
+	}
+	fmt.Fprintf(out, "  run lcr-build-files/unpack.sh\n")
+	return nil
+}
+
+// runInstallFrom implements `lcr install --from <dir|url> [--name name]`.
+func runInstallFrom(source, name string, dryRun bool) {
+	m := &model{packages: make(map[string]*indexEntry)}
+	if err := m.loadPackages(); err != nil {
+		// Only dependencies need the index.
+		slog.Warn("Could not load packages", "err", err)
+	}
+	if dryRun {
+		if err := m.planInstallFrom(source, name); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	confirmOrExit("install "+source, false, func() error { return m.planInstallFrom(source, name) })
+	m.begin("install")
+	name, err := m.installFrom(source, name)
+	m.commit(err)
+	if err != nil {
+		slog.Error("Install failed", "source", source, "err", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Package %s installed successfully from %s.\n", name, source)
+}
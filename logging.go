@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// rotatingFile is a log file that is renamed to path.1 (and path.1 to
+// path.2, and so on) once it grows past maxSize.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		from := r.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		to := fmt.Sprintf("%s.%d", r.path, i)
+		if err := os.Rename(from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if r.maxFiles < 2 {
+		if err := os.Truncate(r.path, 0); err != nil {
+			return err
+		}
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// fanoutHandler sends every record to all handlers that accept its level.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, hh := range h {
+		if hh.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, hh := range h {
+		if hh.Enabled(ctx, r.Level) {
+			errs = append(errs, hh.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithGroup(name)
+	}
+	return out
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "text", "":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// setupLogging installs the default slog logger writing to cfg.LogPath.
+// With a non-nil mirror level, records at that level and above are also
+// written to stderr. Messages from the stdlib log package end up here too.
+func setupLogging(mirror *slog.Level) (io.Closer, error) {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openRotatingFile(cfg.LogPath, int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	fileHandler, err := newLogHandler(f, cfg.LogFormat, level)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	handler := fanoutHandler{fileHandler}
+	if mirror != nil {
+		stderrHandler, _ := newLogHandler(os.Stderr, cfg.LogFormat, *mirror)
+		handler = append(handler, stderrHandler)
+	}
+	slog.SetDefault(slog.New(handler))
+	return f, nil
+}
@@ -3,154 +3,367 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func main() {
-	// Set up logging
-	logFile, err := os.OpenFile("/tmp/lcr.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	// Parse global options and load the configuration
+	global := flag.NewFlagSet("lcr", flag.ExitOnError)
+	configPath := global.String("config", "", "Additional configuration file, read after the system and user files")
+	verbose := global.Bool("v", false, "Also print log messages to stderr")
+	debug := global.Bool("debug", false, "Log debug messages and print them to stderr")
+	registerConfigFlags(global)
+	global.Parse(os.Args[1:])
+	if err := loadConfig(*configPath, global); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Set up logging. The TUI owns the terminal, so it never mirrors to stderr.
+	var mirror *slog.Level
+	if *debug {
+		cfg.LogLevel = "debug"
+		mirror = new(slog.Level)
+		*mirror = slog.LevelDebug
+	} else if *verbose {
+		mirror = new(slog.Level)
+		*mirror = slog.LevelInfo
+	}
+	if global.Arg(0) == "ui" {
+		mirror = nil
+	}
+	logFile, err := setupLogging(mirror)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not open log file: %v\n", err)
 		os.Exit(1)
 	}
 	defer logFile.Close()
-	log.SetOutput(logFile)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
 	// Parse command-line arguments
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: lcr <command> [arguments]")
-		fmt.Println("Commands: ui, install, remove, update, upgrade, find, refresh")
+	if global.NArg() < 1 {
+		fmt.Println("Usage: lcr [options] <command> [arguments]")
+		fmt.Println("Commands: ui, install, remove, update, upgrade, autoremove, find, info, new, lint, test, index, submit, refresh, history, config, gc")
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
+	command := global.Arg(0)
+	args := global.Args()[1:]
 
 	switch command {
 	case "ui":
 		// Launch the TUI
 		p := tea.NewProgram(initialModel(), tea.WithAltScreen())
 		if _, err := p.Run(); err != nil {
-			log.Printf("Error running TUI: %v", err)
+			slog.Error("TUI failed", "err", err)
 			fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
 			os.Exit(1)
 		}
 	case "install":
 		pkg := flag.String("pkg", "", "Package name to install")
-		flag.CommandLine.Parse(os.Args[2:])
+		dryRun := flag.Bool("dry-run", false, "Show what would be done without doing it")
+		from := flag.String("from", "", "Install an unlisted package from a directory or repository URL")
+		name := flag.String("name", "", "Package name for --from, by default taken from its manifest or path")
+		registerYesFlags(flag.CommandLine)
+		rest := parseCommandFlags(flag.CommandLine, args, 1)
+		if *from != "" {
+			runInstallFrom(*from, *name, *dryRun)
+			return
+		}
+		if *pkg == "" && len(rest) == 1 {
+			*pkg = rest[0]
+		}
 		if *pkg == "" {
 			fmt.Println("Error: package name required for install")
 			os.Exit(1)
 		}
-		m := &model{packages: make(map[string]string)}
+		m := &model{packages: make(map[string]*indexEntry)}
 		if err := m.loadPackages(); err != nil {
-			log.Printf("Error loading packages: %v", err)
+			slog.Error("Could not load packages", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		if err := m.install(*pkg); err != nil {
-			log.Printf("Error installing package %s: %v", *pkg, err)
+		if *dryRun {
+			if err := m.planInstall(*pkg); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		confirmOrExit("install "+*pkg, false, func() error { return m.planInstall(*pkg) })
+		m.begin("install")
+		err := m.install(*pkg)
+		m.commit(err)
+		if err != nil {
+			slog.Error("Install failed", "package", *pkg, "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf("Package %s installed successfully.\n", *pkg)
 	case "remove":
 		pkg := flag.String("pkg", "", "Package name to remove")
-		flag.CommandLine.Parse(os.Args[2:])
+		dryRun := flag.Bool("dry-run", false, "Show what would be done without doing it")
+		registerYesFlags(flag.CommandLine)
+		rest := parseCommandFlags(flag.CommandLine, args, 1)
+		if *pkg == "" && len(rest) == 1 {
+			*pkg = rest[0]
+		}
 		if *pkg == "" {
 			fmt.Println("Error: package name required for remove")
 			os.Exit(1)
 		}
-		m := &model{packages: make(map[string]string)}
+		m := &model{packages: make(map[string]*indexEntry)}
 		if err := m.loadPackages(); err != nil {
-			log.Printf("Error loading packages: %v", err)
+			slog.Error("Could not load packages", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		if err := m.remove(*pkg); err != nil {
-			log.Printf("Error removing package %s: %v", *pkg, err)
+		if *dryRun {
+			if err := m.planRemove(*pkg); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		confirmOrExit("remove "+*pkg, true, func() error { return m.planRemove(*pkg) })
+		m.begin("remove")
+		err := m.remove(*pkg)
+		m.commit(err)
+		if err != nil {
+			slog.Error("Remove failed", "package", *pkg, "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf("Package %s removed successfully.\n", *pkg)
 	case "update":
 		pkg := flag.String("pkg", "", "Package name to update")
-		flag.CommandLine.Parse(os.Args[2:])
+		dryRun := flag.Bool("dry-run", false, "Show what would be done without doing it")
+		registerYesFlags(flag.CommandLine)
+		rest := parseCommandFlags(flag.CommandLine, args, 1)
+		if *pkg == "" && len(rest) == 1 {
+			*pkg = rest[0]
+		}
 		if *pkg == "" {
 			fmt.Println("Error: package name required for update")
 			os.Exit(1)
 		}
-		m := &model{packages: make(map[string]string)}
+		m := &model{packages: make(map[string]*indexEntry)}
 		if err := m.loadPackages(); err != nil {
-			log.Printf("Error loading packages: %v", err)
+			slog.Error("Could not load packages", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		if err := m.update(*pkg); err != nil {
-			log.Printf("Error updating package %s: %v", *pkg, err)
+		if *dryRun {
+			if err := m.planUpdate(*pkg); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		confirmOrExit("update "+*pkg, true, func() error { return m.planUpdate(*pkg) })
+		m.begin("update")
+		err := m.update(*pkg)
+		m.commit(err)
+		if err != nil {
+			slog.Error("Update failed", "package", *pkg, "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf("Package %s updated successfully.\n", *pkg)
 	case "upgrade":
-		m := &model{packages: make(map[string]string)}
+		dryRun := flag.Bool("dry-run", false, "Show what would be done without doing it")
+		registerYesFlags(flag.CommandLine)
+		parseCommandFlags(flag.CommandLine, args, 0)
+		m := &model{packages: make(map[string]*indexEntry)}
 		if err := m.loadPackages(); err != nil {
-			log.Printf("Error loading packages: %v", err)
+			slog.Error("Could not load packages", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		if err := m.upgrade(); err != nil {
-			log.Printf("Error upgrading packages: %v", err)
+		if *dryRun {
+			if err := m.planUpgrade(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		confirmOrExit("upgrade", true, m.planUpgrade)
+		m.begin("upgrade")
+		err := m.upgrade()
+		m.commit(err)
+		if err != nil {
+			slog.Error("Upgrade failed", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("All packages upgraded successfully.")
+	case "autoremove":
+		dryRun := flag.Bool("dry-run", false, "Show what would be done without doing it")
+		registerYesFlags(flag.CommandLine)
+		parseCommandFlags(flag.CommandLine, args, 0)
+		m := &model{packages: make(map[string]*indexEntry)}
+		if *dryRun {
+			if err := m.planAutoremove(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		names, err := unneededPackages()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(names) == 0 {
+			fmt.Println("No unneeded dependencies to remove.")
+			return
+		}
+		confirmOrExit("autoremove", true, m.planAutoremove)
+		m.begin("autoremove")
+		err = m.autoremove()
+		m.commit(err)
+		if err != nil {
+			slog.Error("Autoremove failed", "err", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s.\n", strings.Join(names, ", "))
 	case "find":
 		query := flag.String("query", "", "Search query for packages")
-		flag.CommandLine.Parse(os.Args[2:])
-		if *query == "" {
+		exact := flag.Bool("exact", false, "Match the package name exactly")
+		regex := flag.Bool("regex", false, "Treat the query as a regular expression")
+		installed := flag.Bool("installed", false, "Only search installed packages")
+		rest := parseCommandFlags(flag.CommandLine, args, 1)
+		if *query == "" && len(rest) == 1 {
+			*query = rest[0]
+		}
+		if *query == "" && !*installed {
 			fmt.Println("Error: search query required for find")
 			os.Exit(1)
 		}
-		m := &model{packages: make(map[string]string), query: *query}
+		m := &model{
+			packages:   make(map[string]*indexEntry),
+			query:      *query,
+			searchOpts: searchOptions{exact: *exact, regex: *regex, installed: *installed},
+		}
 		if err := m.loadPackages(); err != nil {
-			log.Printf("Error loading packages: %v", err)
+			slog.Error("Could not load packages", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		// Call find and assert the tea.Model to *model
-		updatedModel, _ := m.find()
-		m, ok := updatedModel.(*model)
-		if !ok {
-			log.Println("Error: type assertion failed for find result")
-			fmt.Fprintln(os.Stderr, "Error: internal type assertion failed")
-			os.Exit(1)
-		}
+		m.find()
 		if m.state == stateResult {
 			fmt.Println(m.result)
 		} else {
-			for _, i := range m.list.Items() {
-				pkg, ok := i.(item)
-				if !ok {
-					log.Println("Error: type assertion failed for list item")
-					continue
-				}
-				fmt.Printf("%s: %s\n", pkg.title, pkg.desc)
+			for _, r := range m.found {
+				fmt.Printf("%s: %s\n", r.highlightedName(), r.summary())
 			}
 		}
+	case "info":
+		if len(args) != 1 {
+			fmt.Println("Error: package name required for info")
+			os.Exit(1)
+		}
+		m := &model{packages: make(map[string]*indexEntry)}
+		if err := m.loadPackages(); err != nil {
+			slog.Error("Could not load packages", "err", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		e, ok := m.packages[args[0]]
+		if !ok && !installedSet()[args[0]] {
+			fmt.Fprintf(os.Stderr, "Error: package %s not found\n", args[0])
+			os.Exit(1)
+		}
+		d, err := fetchDetails(args[0], e)
+		if err != nil {
+			slog.Error("Could not fetch package details", "package", args[0], "err", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		m.details = d
+		fmt.Printf("Name:       %s\n%s", d.name, m.detailsView())
 	case "refresh":
-		m := &model{packages: make(map[string]string)}
+		m := &model{packages: make(map[string]*indexEntry)}
 		if err := m.loadPackages(); err != nil {
-			log.Printf("Error refreshing package list: %v", err)
+			slog.Error("Could not refresh package list", "err", err)
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("Package list refreshed successfully.")
+	case "new":
+		if err := runNewCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "lint":
+		if err := runLintCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "test":
+		if err := runTestCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "index":
+		if err := runIndexCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "submit":
+		if err := runSubmitCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "history":
+		registerYesFlags(flag.CommandLine)
+		rest := parseCommandFlags(flag.CommandLine, args, 2)
+		if err := runHistoryCommand(rest); err != nil {
+			slog.Error("History command failed", "err", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "gc":
+		if err := runGCCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "config":
+		if err := runConfigCommand(args); err != nil {
+			slog.Error("Config command failed", "err", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Commands: ui, install, remove, update, upgrade, find, refresh")
+		fmt.Println("Commands: ui, install, remove, update, upgrade, autoremove, find, info, new, lint, test, index, submit, refresh, history, config, gc")
 		os.Exit(1)
 	}
 }
+
+// parseCommandFlags parses the flags of a command wherever they appear, so
+// that `lcr install foo --dry-run` does not quietly ignore --dry-run, and
+// returns the positional arguments. More than max of them, or one that
+// looks like a flag, is an error.
+func parseCommandFlags(fs *flag.FlagSet, args []string, max int) []string {
+	var rest []string
+	fs.Parse(args)
+	for fs.NArg() > 0 {
+		rest = append(rest, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	for _, arg := range rest {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprintf(os.Stderr, "Error: unexpected argument %s\n", arg)
+			os.Exit(2)
+		}
+	}
+	if len(rest) > max {
+		fmt.Fprintf(os.Stderr, "Error: too many arguments: %s\n", strings.Join(rest, " "))
+		os.Exit(2)
+	}
+	return rest
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+const manifestPath = "lcr-build-files/lcr.toml"
+
+// manifest is the optional lcr-build-files/lcr.toml of a package:
+//
+//	name = "vira"
+//	version = "0.3.0"
+//	description = "The Vira programming language"
+//	depends = ["libvira"]
+//	conflicts = ["vira-git"]
+//	provides = ["vira-compiler"]
+//	requires = ["make", "cc"]
+//	arch = ["x86_64", "aarch64"]
+//	files = ["bin/vira"]
+//
+//	[scripts]
+//	unpack = "build.sh"
+//
+// Every key is optional. files lists paths below the prefix the package
+// installs; system hooks match their paths patterns against it. With
+// [[install]] entries lcr installs the files itself instead of running the
+// unpack script.
+type manifest struct {
+	Name        string          `toml:"name"`
+	Version     string          `toml:"version"`
+	Description string          `toml:"description"`
+	Depends     []string        `toml:"depends"`
+	Conflicts   []string        `toml:"conflicts"`
+	Provides    []string        `toml:"provides"`
+	Requires    []string        `toml:"requires"`
+	Arch        []string        `toml:"arch"`
+	Prefix      string          `toml:"prefix"`
+	Files       []string        `toml:"files"`
+	Scripts     manifestScripts `toml:"scripts"`
+	Install     []installEntry  `toml:"install"`
+}
+
+type manifestScripts struct {
+	Unpack string `toml:"unpack"`
+	Remove string `toml:"remove"`
+}
+
+// readManifest reads the manifest of a package checkout. A package without
+// one gets nil and no error.
+func readManifest(fs billy.Filesystem) (*manifest, error) {
+	f, err := fs.Open(manifestPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mf := &manifest{}
+	if _, err := toml.NewDecoder(f).Decode(mf); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestPath, err)
+	}
+	return mf, nil
+}
+
+func loadManifest(dir string) (*manifest, error) {
+	return readManifest(osfs.New(dir))
+}
+
+// archNames maps GOARCH to the names a manifest may use for it.
+var archNames = map[string][]string{
+	"amd64":   {"x86_64", "amd64"},
+	"386":     {"i686", "i386", "x86"},
+	"arm64":   {"aarch64", "arm64"},
+	"arm":     {"armv7", "armhf", "arm"},
+	"riscv64": {"riscv64"},
+	"ppc64le": {"ppc64le"},
+	"s390x":   {"s390x"},
+}
+
+func (mf *manifest) supportsArch() bool {
+	if len(mf.Arch) == 0 {
+		return true
+	}
+	for _, a := range mf.Arch {
+		if a == "any" || slices.Contains(archNames[runtime.GOARCH], a) || a == runtime.GOARCH {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaErrors checks a manifest on its own, without looking at the system.
+func (mf *manifest) schemaErrors() []error {
+	var errs []error
+	if mf.Name != "" {
+		if err := validatePackageName(mf.Name); err != nil {
+			errs = append(errs, fmt.Errorf("manifest: %w", err))
+		}
+	}
+	for _, list := range [][]string{mf.Depends, mf.Conflicts, mf.Provides} {
+		for _, name := range list {
+			if err := validatePackageName(name); err != nil {
+				errs = append(errs, fmt.Errorf("manifest: %w", err))
+			}
+		}
+	}
+	for _, script := range []string{mf.Scripts.Unpack, mf.Scripts.Remove} {
+		if script != "" && !filepath.IsLocal(script) {
+			errs = append(errs, fmt.Errorf("manifest: script %q must be a path inside lcr-build-files", script))
+		}
+	}
+	for _, e := range mf.Install {
+		if err := e.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("manifest: %w", err))
+		}
+	}
+	return errs
+}
+
+// validate checks a manifest on its own and against the package it was
+// installed as.
+func (mf *manifest) validate(pakiet string) error {
+	if mf.Name != "" && mf.Name != pakiet {
+		return fmt.Errorf("manifest names the package %q but the index calls it %q", mf.Name, pakiet)
+	}
+	if errs := mf.schemaErrors(); len(errs) > 0 {
+		return errs[0]
+	}
+	if !mf.supportsArch() {
+		return fmt.Errorf("%s does not support %s (supported: %v)", pakiet, runtime.GOARCH, mf.Arch)
+	}
+	var missing []string
+	for _, tool := range mf.Requires {
+		if !haveTool(tool) {
+			missing = append(missing, tool)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s needs %v, which could not be found", pakiet, missing)
+	}
+	if mf.Prefix != "" && mf.Prefix != cfg.Prefix {
+		slog.Warn("Package expects a different prefix", "package", pakiet, "expects", mf.Prefix, "prefix", cfg.Prefix)
+	}
+	return nil
+}
+
+// haveTool looks a required tool up in PATH, or in the usual directories of
+// the alternate root when there is one.
+func haveTool(tool string) bool {
+	if cfg.Root == "" {
+		_, err := exec.LookPath(tool)
+		return err == nil
+	}
+	for _, dir := range []string{"/usr/local/bin", "/usr/bin", "/bin", "/usr/sbin", "/sbin"} {
+		if info, err := os.Stat(filepath.Join(cfg.Root, dir, tool)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// packageScript is the unpack or remove script of a checkout: the one named
+// in the manifest, or unpack.sh and remove.sh.
+func packageScript(dir, kind string) string {
+	name := kind + ".sh"
+	if mf, err := loadManifest(dir); err == nil && mf != nil {
+		switch {
+		case kind == "unpack" && mf.Scripts.Unpack != "":
+			name = mf.Scripts.Unpack
+		case kind == "remove" && mf.Scripts.Remove != "":
+			name = mf.Scripts.Remove
+		}
+	}
+	return filepath.Join(dir, "lcr-build-files", name)
+}
+
+// checkManifest validates the manifest of a freshly cloned or pulled
+// package, refuses it if it conflicts with an installed package and
+// installs its missing dependencies.
+func (m *model) checkManifest(pakiet string) error {
+	mf, err := loadManifest(packageDir(pakiet))
+	if err != nil || mf == nil {
+		return err
+	}
+	if err := mf.validate(pakiet); err != nil {
+		return err
+	}
+	installed, err := listInstalled()
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, p := range installed {
+		if p.Name == pakiet {
+			continue
+		}
+		if slices.Contains(mf.Conflicts, p.Name) || slices.Contains(p.Conflicts, pakiet) {
+			return fmt.Errorf("%s conflicts with installed package %s", pakiet, p.Name)
+		}
+		for _, name := range p.Provides {
+			if slices.Contains(mf.Conflicts, name) {
+				return fmt.Errorf("%s conflicts with %s, provided by installed package %s", pakiet, name, p.Name)
+			}
+			have[name] = true
+		}
+		have[p.Name] = true
+	}
+	for _, dep := range mf.Depends {
+		if have[dep] || m.resolving[dep] {
+			continue
+		}
+		if _, err := os.Stat(packageDir(dep)); err == nil {
+			// Installed by an earlier dependency.
+			continue
+		}
+		if _, ok := m.packages[dep]; !ok {
+			return fmt.Errorf("%s depends on %s, which is not in the index", pakiet, dep)
+		}
+		slog.Info("Installing dependency", "package", pakiet, "dependency", dep)
+		if m.resolving == nil {
+			m.resolving = make(map[string]bool)
+		}
+		m.resolving[pakiet] = true
+		err := m.install(dep)
+		delete(m.resolving, pakiet)
+		if err != nil {
+			return fmt.Errorf("dependency %s: %w", dep, err)
+		}
+		if err := markDependency(dep); err != nil {
+			slog.Warn("Could not mark package as a dependency", "package", dep, "err", err)
+		}
+	}
+	return nil
+}
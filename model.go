@@ -2,9 +2,14 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"strings"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -16,6 +21,19 @@ var (
 	blueColor    = lipgloss.Color("#0000FF")
 	purpleColor  = lipgloss.Color("#800080")
 	yellowColor  = lipgloss.Color("#FFFF00")
+
+	titleStyle, subtitleStyle, successStyle, errorStyle, infoStyle lipgloss.Style
+	listStyle, docStyle, headerStyle, footerStyle, matchStyle      lipgloss.Style
+	diffAddStyle, diffDelStyle, shellKeywordStyle, shellStringStyle lipgloss.Style
+	shellVarStyle, shellCommentStyle                               lipgloss.Style
+)
+
+func init() {
+	buildStyles()
+}
+
+// buildStyles derives every style from the current colors.
+func buildStyles() {
 	titleStyle   = lipgloss.NewStyle().
 	Bold(true).
 	Foreground(goldColor).
@@ -56,31 +74,91 @@ var (
 	docStyle = lipgloss.NewStyle().Margin(1, 2, 0, 2)
 	headerStyle = lipgloss.NewStyle().Bold(true).Foreground(yellowColor).Align(lipgloss.Center).Margin(1)
 	footerStyle = lipgloss.NewStyle().Foreground(purpleColor).Align(lipgloss.Center).Margin(1)
-)
+	matchStyle = lipgloss.NewStyle().Foreground(greenColor).Bold(true)
+	diffAddStyle = lipgloss.NewStyle().Foreground(greenColor)
+	diffDelStyle = lipgloss.NewStyle().Foreground(redColor)
+	shellKeywordStyle = lipgloss.NewStyle().Foreground(blueColor).Bold(true)
+	shellStringStyle = lipgloss.NewStyle().Foreground(greenColor)
+	shellVarStyle = lipgloss.NewStyle().Foreground(yellowColor)
+	shellCommentStyle = lipgloss.NewStyle().Foreground(purpleColor).Italic(true)
+}
+
+// applyTheme replaces the colors set in the configuration and rebuilds the
+// styles.
+func applyTheme(t themeConfig) {
+	for _, c := range []struct {
+		value string
+		color *lipgloss.Color
+	}{
+		{t.Primary, &goldColor},
+		{t.Secondary, &blueColor},
+		{t.Success, &greenColor},
+		{t.Error, &redColor},
+		{t.Muted, &purpleColor},
+		{t.Highlight, &yellowColor},
+	} {
+		if c.value != "" {
+			*c.color = lipgloss.Color(c.value)
+		}
+	}
+	buildStyles()
+}
 
 type state string
 
 const (
 	stateMenu      state = "menu"
-	stateInputPakiet state = "input_pakiet"
 	stateFindQuery   state = "find_query"
 	stateExec        state = "exec"
 	stateResult      state = "result"
 	stateHelp        state = "help"
 	stateHowToAdd    state = "how_to_add"
 	stateList        state = "list"
+	stateInstalled   state = "installed"
+	statePick        state = "pick"
+	stateConfirm     state = "confirm"
+	stateDetails     state = "details"
+	stateReview      state = "review"
 )
 
 type model struct {
 	state      state
 	choice     string
-	pakiet     string
+	pakiets    []string
 	query      string
 	result     string
 	list       list.Model
+	results    list.Model
+	details    *packageDetails
 	textinput  textinput.Model
-	packages   map[string]string
+	packages   map[string]*indexEntry
+	tx         *transaction
+	reviewer   func(scriptReview) bool
+	resolving  map[string]bool
+	searchOpts searchOptions
+	found      []searchResult
 	err        error
+
+	installed     []*installedPackage
+	installedList list.Model
+	pickList      list.Model
+	confirmBack   state
+	review        *scriptReviewMsg
+	reviewView    viewport.Model
+
+	// Background execution (TUI only).
+	stdout      io.Writer
+	gitProgress io.Writer
+	events      <-chan tea.Msg
+	status      string
+	spinner     spinner.Model
+	progress    progress.Model
+	output      viewport.Model
+	outputLines []string
+	stage       string
+	percent     float64
+	width       int
+	height      int
 }
 
 type item struct {
@@ -107,6 +185,7 @@ func initialModel() *model {
 		item{title: "upgrade", desc: "Upgrade all packages"},
 		item{title: "find", desc: "Find packages"},
 		item{title: "refresh", desc: "Refresh package list"},
+		item{title: "installed", desc: "Browse installed packages"},
 		item{title: "help", desc: "Show help"},
 		item{title: "how-to-add", desc: "How to add your own repo"},
 		item{title: "exit", desc: "Exit the application"},
@@ -122,11 +201,56 @@ func initialModel() *model {
 	l.Title = "Legendary Community Repository"
 	l.Styles.Title = titleStyle
 
+	sp := spinner.New()
+	sp.Spinner = spinner.Dot
+	sp.Style = lipgloss.NewStyle().Foreground(goldColor)
+
+	il := list.New(nil, delegate, 0, 0)
+	il.Title = "Installed Packages"
+	il.Styles.Title = subtitleStyle
+	il.DisableQuitKeybindings()
+
+	pl := list.New(nil, delegate, 0, 0)
+	pl.Styles.Title = subtitleStyle
+	pl.DisableQuitKeybindings()
+
 	return &model{
 		state:     stateMenu,
 		textinput: ti,
 		list:      l,
-		packages:  make(map[string]string),
+		packages:  make(map[string]*indexEntry),
+		installedList: il,
+		pickList:  pl,
+		spinner:   sp,
+		progress:  progress.New(progress.WithGradient(string(goldColor), string(greenColor))),
+		output:    viewport.New(0, 0),
+		reviewView: viewport.New(0, 0),
+	}
+}
+
+// resize lays out the size-dependent widgets.
+func (m *model) resize(width, height int) {
+	m.width, m.height = width, height
+	h, v := docStyle.GetFrameSize()
+	m.list.SetSize(width-h, height-v)
+	m.installedList.SetSize(width-h, height-v-2)
+	m.pickList.SetSize(width-h, height-v-2)
+	m.results.SetSize(width-h, height-v-2)
+	m.progress.Width = min(width-h, 60)
+	m.output.Width = width - h
+	m.output.Height = max(height-v-12, 3)
+	m.reviewView.Width = width - h
+	m.reviewView.Height = max(height-v-10, 3)
+}
+
+// appendOutput adds a line of script output to the output pane, keeping it
+// scrolled to the bottom unless the user scrolled up.
+func (m *model) appendOutput(line string) {
+	follow := m.output.AtBottom()
+	m.outputLines = append(m.outputLines, line)
+	m.output.SetContent(strings.Join(m.outputLines, "\n"))
+	if follow {
+		m.output.GotoBottom()
 	}
 }
 
@@ -135,135 +259,219 @@ func (m *model) Init() tea.Cmd {
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.WindowSizeMsg); ok {
+		m.resize(msg.Width, msg.Height)
+	}
 	switch m.state {
 		case stateMenu:
 			switch msg := msg.(type) {
 				case tea.KeyMsg:
 					if msg.String() == "ctrl+c" || msg.String() == "q" {
-						log.Println("User exited via ctrl+c or q")
+						slog.Debug("User exited via ctrl+c or q")
 						return m, tea.Quit
 					}
-				case tea.WindowSizeMsg:
-					h, v := docStyle.GetFrameSize()
-					m.list.SetSize(msg.Width-h, msg.Height-v)
 			}
 			var cmd tea.Cmd
 			m.list, cmd = m.list.Update(msg)
 			if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == "enter" {
 				selected := m.list.SelectedItem().(item)
 				m.choice = selected.title
-				log.Printf("Selected command: %s", m.choice)
+				slog.Debug("Selected command", "command", m.choice)
 				if m.choice == "exit" {
-					log.Println("Exiting application")
+					slog.Debug("Exiting application")
 					return m, tea.Quit
-				} else if m.choice == "upgrade" || m.choice == "refresh" || m.choice == "help" || m.choice == "how-to-add" {
+				} else if m.choice == "upgrade" {
+					m.pakiets = nil
+					_, confirmCmd := m.askConfirm(stateMenu)
+					return m, tea.Batch(cmd, confirmCmd)
+				} else if m.choice == "refresh" || m.choice == "installed" {
 					m.state = stateExec
+					return m, tea.Batch(cmd, m.startExec())
+				} else if m.choice == "help" {
+					m.state = stateHelp
+					slog.Debug("Switched to help state")
+				} else if m.choice == "how-to-add" {
+					m.state = stateHowToAdd
+					slog.Debug("Switched to how-to-add state")
 				} else if m.choice == "find" {
 					m.state = stateFindQuery
 					m.textinput.Placeholder = "Enter search query..."
 					m.textinput.Focus()
-					log.Println("Switched to find query state")
+					slog.Debug("Switched to find query state")
 				} else {
-					m.state = stateInputPakiet
-					m.textinput.Placeholder = "Enter package name..."
-					m.textinput.Focus()
-					log.Println("Switched to input package state")
+					m.state = stateExec
+					slog.Debug("Opening package picker", "action", m.choice)
+					return m, tea.Batch(cmd, m.startPicker())
 				}
 			}
 			return m, cmd
-				case stateInputPakiet, stateFindQuery:
+				case stateFindQuery:
 					switch msg := msg.(type) {
 						case tea.KeyMsg:
 							if msg.String() == "esc" {
-								log.Println("Cancelled input, returning to menu")
+								slog.Debug("Cancelled input, returning to menu")
 								m.state = stateMenu
 								m.textinput.Reset()
 								return m, nil
 							}
 							if msg.String() == "enter" {
-								if m.state == stateInputPakiet {
-									m.pakiet = m.textinput.Value()
-									log.Printf("Package name entered: %s", m.pakiet)
-								} else {
-									m.query = m.textinput.Value()
-									log.Printf("Search query entered: %s", m.query)
-								}
+								m.query = m.textinput.Value()
+								slog.Debug("Search query entered", "query", m.query)
 								m.textinput.Reset()
 								m.state = stateExec
-								return m, nil
+								return m, m.startExec()
 							}
 					}
 					var cmd tea.Cmd
 					m.textinput, cmd = m.textinput.Update(msg)
 					return m, cmd
 						case stateExec:
-							m.err = m.loadPackages()
-							if m.err != nil {
-								log.Println("Error loading packages:", m.err)
-								m.result = errorStyle.Render(fmt.Sprintf("Error: %v", m.err))
-								m.state = stateResult
-								return m, nil
-							}
-							switch m.choice {
-								case "install":
-									m.err = m.install(m.pakiet)
-								case "remove":
-									m.err = m.remove(m.pakiet)
-								case "update":
-									m.err = m.update(m.pakiet)
-								case "upgrade":
-									m.err = m.upgrade()
-								case "find":
-									m.state = stateList
-									return m.find()
-								case "refresh":
-									m.result = successStyle.Render("Package list refreshed successfully.")
-									m.state = stateResult
-									log.Println("Package list refresh executed")
-									return m, nil
-								case "help":
-									m.state = stateHelp
-									log.Println("Switched to help state")
-									return m, nil
-								case "how-to-add":
-									m.state = stateHowToAdd
-									log.Println("Switched to how-to-add state")
-									return m, nil
-							}
-							if m.err != nil {
-								log.Println("Execution error:", m.err)
-								m.result = errorStyle.Render(fmt.Sprintf("Error: %v", m.err))
-							} else {
-								m.result = successStyle.Render(fmt.Sprintf("%s executed successfully for %s.", m.choice, m.pakiet))
+							switch msg := msg.(type) {
+								case tea.KeyMsg:
+									if msg.String() == "ctrl+c" {
+										slog.Warn("User aborted a running operation", "action", m.choice)
+										return m, tea.Quit
+									}
+								case spinner.TickMsg:
+									var cmd tea.Cmd
+									m.spinner, cmd = m.spinner.Update(msg)
+									return m, cmd
+								case execOutputMsg:
+									m.appendOutput(string(msg))
+									return m, waitForEvent(m.events)
+								case execProgressMsg:
+									m.stage, m.percent = msg.stage, msg.percent
+									return m, waitForEvent(m.events)
+								case execDoneMsg:
+									return m.finishExec(msg)
+								case pickerLoadedMsg:
+									return m.showPicker(msg)
+								case detailsLoadedMsg:
+									return m.showDetails(msg)
+								case scriptReviewMsg:
+									return m.showReview(msg)
 							}
-							m.state = stateResult
-							return m, nil
+							var cmd tea.Cmd
+							m.output, cmd = m.output.Update(msg)
+							return m, cmd
 								case stateList:
 									switch msg := msg.(type) {
 										case tea.KeyMsg:
+											if m.results.FilterState() == list.Filtering {
+												break
+											}
 											if msg.String() == "esc" || msg.String() == "q" {
-												log.Println("Exiting list view, returning to menu")
+												slog.Debug("Exiting list view, returning to menu")
 												m.state = stateMenu
 												return m, nil
 											}
-										case tea.WindowSizeMsg:
-											h, v := docStyle.GetFrameSize()
-											m.list.SetSize(msg.Width-h, msg.Height-v)
+											if msg.String() == "enter" {
+												if selected, ok := m.results.SelectedItem().(resultItem); ok {
+													m.state = stateExec
+													return m, m.startDetails(selected.entry.Name)
+												}
+											}
 									}
 									var cmd tea.Cmd
-									m.list, cmd = m.list.Update(msg)
+									m.results, cmd = m.results.Update(msg)
 									return m, cmd
+										case stateDetails:
+											return m.updateDetails(msg)
+										case stateReview:
+											return m.updateReview(msg)
+										case stateInstalled:
+											if msg, ok := msg.(tea.KeyMsg); ok && m.installedList.FilterState() == list.Unfiltered {
+												selected, _ := m.installedList.SelectedItem().(item)
+												switch msg.String() {
+													case "esc", "q":
+														slog.Debug("Exiting installed view, returning to menu")
+														m.state = stateMenu
+														return m, nil
+													case "enter":
+														for _, p := range m.installed {
+															if p.Name == selected.title {
+																m.result = infoStyle.Render(p.info())
+																m.outputLines = nil
+																m.state = stateResult
+																return m, nil
+															}
+														}
+													case "u", "r", "i":
+														if selected.title == "" {
+															return m, nil
+														}
+														m.choice = map[string]string{"u": "update", "r": "remove", "i": "reinstall"}[msg.String()]
+														m.pakiets = []string{selected.title}
+														slog.Debug("Action selected from installed view", "action", m.choice, "package", selected.title)
+														return m.askConfirm(stateInstalled)
+												}
+											}
+											var cmd tea.Cmd
+											m.installedList, cmd = m.installedList.Update(msg)
+											return m, cmd
+										case statePick:
+											return m.updatePick(msg)
+										case stateConfirm:
+											return m.updateConfirm(msg)
 										case stateResult, stateHelp, stateHowToAdd:
 											switch msg := msg.(type) {
 												case tea.KeyMsg:
 													if msg.String() == "enter" || msg.String() == "esc" || msg.String() == "q" {
-														log.Println("Returning to menu from", m.state)
+														slog.Debug("Returning to menu", "from", m.state)
 														m.state = stateMenu
 														m.result = ""
 														return m, nil
 													}
 											}
-											return m, nil
+											var cmd tea.Cmd
+											m.output, cmd = m.output.Update(msg)
+											return m, cmd
+	}
+	return m, nil
+}
+
+func installedItems(pkgs []*installedPackage) []list.Item {
+	items := make([]list.Item, 0, len(pkgs))
+	for _, p := range pkgs {
+		desc := fmt.Sprintf("%s · installed %s", shortCommit(p.Commit), p.InstalledAt.Format("2006-01-02"))
+		if p.Upgradable {
+			desc += " · upgrade available"
+		}
+		items = append(items, item{title: p.Name, desc: desc})
+	}
+	return items
+}
+
+// finishExec applies the result of a background operation to the model.
+func (m *model) finishExec(msg execDoneMsg) (tea.Model, tea.Cmd) {
+	m.events = nil
+	if msg.packages != nil {
+		m.packages = msg.packages
+	}
+	m.err = msg.err
+	m.state = stateResult
+	if m.err != nil {
+		slog.Error("Operation failed", "action", m.choice, "err", m.err)
+		m.result = errorStyle.Render(fmt.Sprintf("Error: %v", m.err))
+		return m, nil
+	}
+	switch m.choice {
+	case "find":
+		m.state = stateList
+		return m.find()
+	case "installed":
+		m.installed = msg.installed
+		m.state = stateInstalled
+		return m, m.installedList.SetItems(installedItems(m.installed))
+	case "refresh":
+		m.result = successStyle.Render("Package list refreshed successfully.")
+		slog.Debug("Package list refresh executed")
+	default:
+		if msg.result != "" && len(m.pakiets) == 1 {
+			m.result = msg.result
+		} else {
+			m.result = successStyle.Render(fmt.Sprintf("%s executed successfully for %s.", m.choice, strings.Join(m.pakiets, ", ")))
+		}
 	}
 	return m, nil
 }
@@ -274,7 +482,7 @@ func (m *model) View() string {
 	switch m.state {
 		case stateMenu:
 			return docStyle.Render(header + "\n" + m.list.View() + "\n" + footer)
-		case stateInputPakiet, stateFindQuery:
+		case stateFindQuery:
 			return fmt.Sprintf(
 				"%s\n\n%s\n\n%s\n\n%s to cancel.",
 		      header,
@@ -283,13 +491,60 @@ func (m *model) View() string {
 					   infoStyle.Render("esc"),
 			)
 		case stateList:
-			return docStyle.Render(header + "\n" + m.list.View() + "\n" + footer)
+			return docStyle.Render(header + "\n" + m.results.View() + "\n" + footerStyle.Render("enter details | / filter | esc back"))
+		case stateDetails:
+			return fmt.Sprintf(
+				"%s\n\n%s\n\n%s\n%s",
+		      header,
+		      titleStyle.Render(m.details.name),
+					   infoStyle.Render(m.detailsView()),
+					   footerStyle.Render(m.detailsActions()),
+			)
+		case stateReview:
+			return fmt.Sprintf(
+				"%s\n\n%s\n\n%s\n%s",
+		      header,
+		      titleStyle.Render("Review "+m.review.review.script),
+					   docStyle.Render(m.reviewView.View()),
+					   footerStyle.Render("↑/↓ scroll | y run it | n reject"),
+			)
+		case stateInstalled:
+			return docStyle.Render(
+				header + "\n" + m.installedList.View() + "\n" +
+				footerStyle.Render("enter info | u update | r remove | i reinstall | esc back"),
+			)
+		case statePick:
+			return docStyle.Render(
+				header + "\n" + m.pickList.View() + "\n" +
+				footerStyle.Render("space select | / filter | enter continue | esc back"),
+			)
+		case stateConfirm:
+			return fmt.Sprintf(
+				"%s\n\n%s\n\n%s\n\n%s",
+		      header,
+		      titleStyle.Render("Confirm"),
+					   m.confirmView(),
+		      infoStyle.Render("Press y or enter to proceed, n or esc to go back."),
+			)
+		case stateExec:
+			status := fmt.Sprintf("%s %s", m.spinner.View(), m.status)
+			if m.stage != "" {
+				status += "\n\n" + infoStyle.Render(m.stage) + "\n" + m.progress.ViewAs(m.percent)
+			}
+			return docStyle.Render(
+				header + "\n" + status + "\n\n" + m.output.View() + "\n" +
+				footerStyle.Render("↑/↓ to scroll output | ctrl+c to abort"),
+			)
 		case stateResult:
+			result := m.result
+			if len(m.outputLines) > 0 {
+				result += "\n\n" + m.output.View()
+			}
 			return fmt.Sprintf(
 				"%s\n\n%s\n\n%s\n\n%s\n\n%s",
 		      header,
 		      titleStyle.Render("Result"),
-					   m.result,
+					   result,
 		      infoStyle.Render("Press enter to return to menu."),
 					   footer,
 			)
@@ -301,6 +556,7 @@ func (m *model) View() string {
 			- upgrade: Updates all packages.
 			- find: Searches for packages in the repository list.
 			- refresh: Refreshes the package list.
+			- installed: Browse installed packages and update, remove or reinstall them.
 			- help: Shows this help.
 			- how-to-add: Shows how to add your own repository.
 			- exit: Exits the application.`)
@@ -314,9 +570,11 @@ func (m *model) View() string {
 			)
 		case stateHowToAdd:
 			howToText := infoStyle.Render(`How to add your own repo:
+			- Create a package skeleton: lcr new <name>
 			- Example repo: https://github.com/LegendaryOS/Sample-repo-lcr/
 			- Guide to creating your own repo: https://github.com/LegendaryOS/lcr/wiki/Creating-your-own-repository-for-lcr
-			- Submit your repo: https://github.com/LegendaryOS/lcr/discussions or https://github.com/LegendaryOS/lcr/issues`)
+			- Check it: lcr lint and lcr test
+			- Prepare a submission: lcr submit <path|url>, then file it at https://github.com/LegendaryOS/lcr/issues`)
 			return fmt.Sprintf(
 				"%s\n\n%s\n\n%s\n\n%s\n\n%s",
 		      header,
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pickItem is a package in the picker list that can be marked with space.
+type pickItem struct {
+	name, desc string
+	picked     bool
+}
+
+func (i pickItem) Title() string {
+	if i.picked {
+		return "[x] " + i.name
+	}
+	return "[ ] " + i.name
+}
+func (i pickItem) Description() string { return i.desc }
+func (i pickItem) FilterValue() string { return i.name }
+
+type pickerLoadedMsg struct {
+	packages  map[string]*indexEntry
+	installed []*installedPackage
+	err       error
+}
+
+// startPicker loads the candidates for m.choice: the index for install, the
+// installed packages for update and remove.
+func (m *model) startPicker() tea.Cmd {
+	choice := m.choice
+	m.status = "Loading packages..."
+	m.outputLines = nil
+	m.output.SetContent("")
+	m.stage = ""
+	load := func() tea.Msg {
+		var msg pickerLoadedMsg
+		if choice == "install" {
+			w := &model{packages: make(map[string]*indexEntry)}
+			if msg.err = w.loadPackages(); msg.err != nil {
+				return msg
+			}
+			msg.packages = w.packages
+		}
+		msg.installed, msg.err = listInstalled()
+		return msg
+	}
+	return tea.Batch(m.spinner.Tick, load)
+}
+
+func (m *model) showPicker(msg pickerLoadedMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		slog.Error("Could not load packages for picker", "err", msg.err)
+		m.result = errorStyle.Render(fmt.Sprintf("Error: %v", msg.err))
+		m.state = stateResult
+		return m, nil
+	}
+	installed := make(map[string]bool)
+	for _, p := range msg.installed {
+		installed[p.Name] = true
+	}
+	var items []list.Item
+	if m.choice == "install" {
+		m.packages = msg.packages
+		names := make([]string, 0, len(msg.packages))
+		for name := range msg.packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			desc := msg.packages[name].Description
+			if desc == "" {
+				desc = msg.packages[name].URL
+			}
+			if installed[name] {
+				desc += " (installed)"
+			}
+			items = append(items, pickItem{name: name, desc: desc})
+		}
+	} else {
+		for _, p := range msg.installed {
+			items = append(items, pickItem{name: p.Name, desc: shortCommit(p.Commit) + " " + p.URL})
+		}
+	}
+	if len(items) == 0 {
+		m.result = infoStyle.Render("No packages to choose from.")
+		m.state = stateResult
+		return m, nil
+	}
+	m.pickList.Title = fmt.Sprintf("Select packages to %s", m.choice)
+	m.state = statePick
+	slog.Debug("Showing package picker", "action", m.choice, "count", len(items))
+	m.pickList.ResetFilter()
+	m.pickList.ResetSelected()
+	return m, m.pickList.SetItems(items)
+}
+
+func (m *model) updatePick(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok && m.pickList.FilterState() != list.Filtering {
+		switch msg.String() {
+		case "esc", "q":
+			if m.pickList.FilterState() == list.FilterApplied && msg.String() == "esc" {
+				break
+			}
+			slog.Debug("Cancelled picker, returning to menu")
+			m.state = stateMenu
+			return m, nil
+		case " ":
+			return m, m.togglePick()
+		case "enter":
+			m.pakiets = m.pickedNames()
+			if len(m.pakiets) == 0 {
+				if selected, ok := m.pickList.SelectedItem().(pickItem); ok {
+					m.pakiets = []string{selected.name}
+				}
+			}
+			if len(m.pakiets) == 0 {
+				return m, nil
+			}
+			return m.askConfirm(statePick)
+		}
+	}
+	var cmd tea.Cmd
+	m.pickList, cmd = m.pickList.Update(msg)
+	return m, cmd
+}
+
+// togglePick flips the mark on the highlighted package. The list only
+// reports the index within the visible items, so look the item up by name.
+func (m *model) togglePick() tea.Cmd {
+	selected, ok := m.pickList.SelectedItem().(pickItem)
+	if !ok {
+		return nil
+	}
+	for i, it := range m.pickList.Items() {
+		if p, ok := it.(pickItem); ok && p.name == selected.name {
+			p.picked = !p.picked
+			return m.pickList.SetItem(i, p)
+		}
+	}
+	return nil
+}
+
+func (m *model) pickedNames() []string {
+	var names []string
+	for _, it := range m.pickList.Items() {
+		if p, ok := it.(pickItem); ok && p.picked {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+func (m *model) updateConfirm(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "y", "enter":
+			slog.Debug("Confirmed action", "action", m.choice, "packages", m.pakiets)
+			m.state = stateExec
+			return m, m.startExec()
+		case "n", "esc", "q":
+			slog.Debug("Cancelled confirmation")
+			m.state = m.confirmBack
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+func (m *model) confirmView() string {
+	if m.choice == "upgrade" {
+		return "Every installed package will be updated and its unpack.sh run again.\n"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "The following %d package(s) will be %s:\n\n", len(m.pakiets), pastTense(m.choice))
+	for _, name := range m.pakiets {
+		fmt.Fprintf(&b, "  • %s\n", name)
+	}
+	if m.choice == "remove" || m.choice == "reinstall" {
+		fmt.Fprintf(&b, "\nTheir remove.sh will run and their checkouts in %s will be deleted.\n", cfg.InstallRoot)
+	}
+	return b.String()
+}
+
+func pastTense(choice string) string {
+	switch choice {
+	case "install":
+		return "installed"
+	case "remove":
+		return "removed"
+	case "reinstall":
+		return "reinstalled"
+	case "update":
+		return "updated"
+	}
+	return choice
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// `lcr test` installs a package into a throwaway staging root, installs it
+// again over itself and removes it, and reports what went wrong. Scripts
+// see the staging root as DESTDIR; a script that ignores DESTDIR is not
+// contained, so lcr refuses to test as root, and watches the real prefixes
+// to report such writes after the fact. Run untrusted packages in a
+// container.
+
+// snapshot maps each file below a root, as seen from inside it, to its mode
+// and content hash or link target.
+type snapshot map[string]string
+
+func takeSnapshot(root string) (snapshot, error) {
+	s := make(snapshot)
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, file)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			s["/"+filepath.ToSlash(rel)] = "-> " + target
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		s["/"+filepath.ToSlash(rel)] = info.Mode().String() + " " + hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return s, err
+}
+
+// diff lists the paths added, removed and changed from s to t.
+func (s snapshot) diff(t snapshot) (added, removed, changed []string) {
+	for path, v := range t {
+		if old, ok := s[path]; !ok {
+			added = append(added, path)
+		} else if old != v {
+			changed = append(changed, path)
+		}
+	}
+	for path := range s {
+		if _, ok := t[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return added, removed, changed
+}
+
+func (s snapshot) paths() []string {
+	var paths []string
+	for path := range s {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// hostSnapshot records the mode, size and modification time of every file
+// below dirs on the real system. Unreadable directories are skipped.
+func hostSnapshot(dirs []string) snapshot {
+	s := make(snapshot)
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				s[file] = fmt.Sprintf("%s %d %d", info.Mode(), info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return s
+}
+
+// watchedDirs are the places on the real system a script that ignores
+// DESTDIR most likely writes to.
+func watchedDirs(allowed []string) []string {
+	dirs := append([]string{hostPath(cfg.Prefix), "/etc", "/opt"}, allowed...)
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".local"), filepath.Join(home, ".config"))
+	}
+	slices.Sort(dirs)
+	return slices.DeleteFunc(slices.Compact(dirs), func(dir string) bool { return dir == "/" })
+}
+
+// copyTree copies a package working tree without its .git directory.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, file)
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir() && d.Name() == ".git":
+			return filepath.SkipDir
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return installFile(file, target, 0)
+	})
+}
+
+// packageTest is the outcome of `lcr test`.
+type packageTest struct {
+	problems []string
+	// host is the watched part of the real system before the test ran.
+	host    snapshot
+	watched []string
+	tmp     string
+	escaped map[string]bool
+}
+
+func (t *packageTest) fail(format string, args ...any) {
+	t.problems = append(t.problems, fmt.Sprintf(format, args...))
+}
+
+// step runs one stage of the cycle and reports whether it succeeded.
+func (t *packageTest) step(w io.Writer, name string, run func() error) bool {
+	fmt.Fprintf(w, "==> %s\n", name)
+	err := run()
+	t.checkHost(name)
+	if err != nil {
+		if code := exitCode(err); code > 0 {
+			t.fail("%s exited with status %d", name, code)
+		} else {
+			t.fail("%s: %v", name, err)
+		}
+		return false
+	}
+	return true
+}
+
+// checkHost reports files on the real system that changed since the test
+// started, which means a script wrote outside DESTDIR.
+func (t *packageTest) checkHost(stage string) {
+	added, removed, changed := t.host.diff(hostSnapshot(t.watched))
+	report := func(paths []string, what string) {
+		for _, path := range paths {
+			if t.escaped[path] || within(path, []string{t.tmp}) {
+				continue
+			}
+			t.escaped[path] = true
+			t.fail("%s %s %s on the real system, outside DESTDIR", stage, what, path)
+		}
+	}
+	report(added, "added")
+	report(removed, "removed")
+	report(changed, "changed")
+}
+
+func within(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if p == "/" || path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// testPackage runs the install, reinstall and remove cycle of the package
+// in dir with everything lcr writes redirected below a temporary directory.
+func testPackage(dir string, allowed []string) (*packageTest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lcr-build-files")); err != nil {
+		return nil, fmt.Errorf("%s has no lcr-build-files directory", dir)
+	}
+	name := filepath.Base(dir)
+	if mf, err := loadManifest(dir); err != nil {
+		return nil, err
+	} else if mf != nil && mf.Name != "" {
+		name = mf.Name
+	}
+	if err := validatePackageName(name); err != nil {
+		return nil, err
+	}
+
+	t := &packageTest{watched: watchedDirs(allowed), escaped: make(map[string]bool)}
+	t.host = hostSnapshot(t.watched)
+	tmp, err := os.MkdirTemp("", "lcr-test-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	t.tmp = tmp
+	staging := filepath.Join(tmp, "root")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return nil, err
+	}
+	cfg.Root = staging
+	cfg.Chroot = false
+	cfg.InstallRoot = filepath.Join(tmp, "packages")
+	cfg.StateDir = filepath.Join(tmp, "state")
+	// The author is testing their own scripts.
+	cfg.ScriptPolicy = "trust"
+	dest := packageDir(name)
+	if err := copyTree(dir, dest); err != nil {
+		return nil, err
+	}
+
+	m := &model{packages: make(map[string]*indexEntry)}
+	out := m.out()
+	install := func() error {
+		if err := m.runPackageHook(name, hookPreInstall); err != nil {
+			return err
+		}
+		if err := m.runUnpack(dest); err != nil {
+			return err
+		}
+		return m.runPackageHook(name, hookPostInstall)
+	}
+	if !t.step(out, "install", install) {
+		return t, nil
+	}
+	installed, err := takeSnapshot(staging)
+	if err != nil {
+		return nil, err
+	}
+	if len(installed) == 0 {
+		t.fail("install did not put any file below DESTDIR")
+	}
+	for _, path := range installed.paths() {
+		if !within(path, allowed) {
+			t.fail("%s is outside the allowed prefixes %v", path, allowed)
+		}
+	}
+
+	reinstall := func() error {
+		if err := m.runUnpack(dest); err != nil {
+			return err
+		}
+		return m.runPackageHook(name, hookPostUpgrade)
+	}
+	if t.step(out, "install again", reinstall) {
+		again, err := takeSnapshot(staging)
+		if err != nil {
+			return nil, err
+		}
+		added, removed, changed := installed.diff(again)
+		for _, path := range added {
+			t.fail("installing again added %s", path)
+		}
+		for _, path := range removed {
+			t.fail("installing again removed %s", path)
+		}
+		for _, path := range changed {
+			t.fail("installing again changed %s", path)
+		}
+	}
+
+	remove := func() error {
+		if err := m.runPackageHook(name, hookPreRemove); err != nil {
+			return err
+		}
+		if script := packageScript(dest, "remove"); fileExists(script) {
+			if err := m.runScript(name, script); err != nil {
+				return err
+			}
+		}
+		return m.removeFiles(name)
+	}
+	if t.step(out, "remove", remove) {
+		left, err := takeSnapshot(staging)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range left.paths() {
+			t.fail("remove left %s behind", path)
+		}
+	}
+	return t, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// runTestCommand implements `lcr test [--allow prefix]... [--unsafe] [dir]`.
+func runTestCommand(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	unsafe := fs.Bool("unsafe", false, "Test even as root, where scripts that ignore DESTDIR change the real system")
+	var allowed []string
+	fs.Func("allow", "Also allow files below this `prefix` (repeatable)", func(s string) error {
+		allowed = append(allowed, filepath.Clean(s))
+		return nil
+	})
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: lcr test [--allow prefix]... [--unsafe] [dir]")
+	}
+	if os.Geteuid() == 0 && !*unsafe {
+		return fmt.Errorf("refusing to test as root: a script that ignores DESTDIR would change the real system; use a container, or --unsafe")
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	allowed = append([]string{cfg.Prefix}, allowed...)
+	t, err := testPackage(dir, allowed)
+	if err != nil {
+		return err
+	}
+	if len(t.problems) == 0 {
+		fmt.Println("All checks passed: install, install again and remove behaved.")
+		return nil
+	}
+	fmt.Println()
+	for _, p := range t.problems {
+		fmt.Printf("FAIL: %s\n", p)
+	}
+	return fmt.Errorf("%d problems found", len(t.problems))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// scaffoldFiles is what `lcr new` writes, keyed by path and rendered with
+// the package name as .Name.
+var scaffoldFiles = []struct {
+	path    string
+	mode    os.FileMode
+	content string
+}{
+	{"README.md", 0644, `# {{.Name}}
+
+Describe {{.Name}} here.
+
+## Installing
+
+    lcr install {{.Name}}
+
+## Publishing
+
+Push this repository and ask for this line to be added to the index:
+
+    {{.Name}} -> https://github.com/YOUR-NAME/{{.Name}}.git
+`},
+	{"bin/{{.Name}}", 0755, `#!/bin/sh
+echo "Hello from {{.Name}}"
+`},
+	{"lcr-build-files/lcr.toml", 0644, `name = "{{.Name}}"
+version = "0.1.0"
+description = "Describe {{.Name}} in one line"
+# depends = []
+# requires = []
+files = ["bin/{{.Name}}", "share/applications/{{.Name}}.desktop"]
+`},
+	{"lcr-build-files/unpack.sh", 0755, `#!/bin/sh
+# Installs {{.Name}}. lcr runs this from lcr-build-files with LCR_PREFIX set,
+# and DESTDIR set when installing into an image. It also runs again on every
+# update, so it must be safe to repeat.
+set -eu
+
+prefix="${DESTDIR:-}${LCR_PREFIX:-/usr}"
+cd ..
+
+install -Dm755 "bin/{{.Name}}" "$prefix/bin/{{.Name}}"
+install -Dm644 "lcr-build-files/{{.Name}}.desktop" "$prefix/share/applications/{{.Name}}.desktop"
+`},
+	{"lcr-build-files/remove.sh", 0755, `#!/bin/sh
+# Removes what unpack.sh installed. Missing files are not an error.
+set -eu
+
+prefix="${DESTDIR:-}${LCR_PREFIX:-/usr}"
+
+rm -f "$prefix/bin/{{.Name}}"
+rm -f "$prefix/share/applications/{{.Name}}.desktop"
+`},
+	{"lcr-build-files/{{.Name}}.desktop", 0644, `[Desktop Entry]
+Type=Application
+Name={{.Name}}
+Comment=Describe {{.Name}} in one line
+Exec={{.Name}}
+Terminal=true
+Categories=Utility;
+`},
+}
+
+// scaffold creates a package repository for name in dir.
+func scaffold(name, dir string) error {
+	if err := validatePackageName(name); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", dir)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	data := struct{ Name string }{name}
+	for _, f := range scaffoldFiles {
+		path, err := render(f.path, data)
+		if err != nil {
+			return err
+		}
+		content, err := render(f.content, data)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), f.mode); err != nil {
+			return err
+		}
+	}
+	_, err := git.PlainInit(dir, false)
+	return err
+}
+
+func render(text string, data any) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
+
+// runNewCommand implements `lcr new [--dir dir] <name>`.
+func runNewCommand(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	dir := fs.String("dir", "", "Directory to create, ./<name> by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: lcr new [--dir dir] <name>")
+	}
+	name := fs.Arg(0)
+	if *dir == "" {
+		*dir = name
+	}
+	if err := scaffold(name, *dir); err != nil {
+		return err
+	}
+	fmt.Printf("Created package %s in %s.\n", name, *dir)
+	fmt.Println("Edit the files, commit them, publish the repository and add this line to the index:")
+	fmt.Printf("\n    %s -> https://github.com/YOUR-NAME/%s.git\n", name, name)
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sahilm/fuzzy"
+)
+
+// Score bonuses so that a hit on the name always ranks above a hit on the
+// tags, which ranks above a hit in the description.
+const (
+	nameMatchBonus = 1000
+	tagMatchBonus  = 500
+	descMatchBonus = 100
+)
+
+type searchOptions struct {
+	exact     bool // match the package name exactly
+	regex     bool // treat the query as a regular expression
+	installed bool // only installed packages
+}
+
+type searchResult struct {
+	entry     *indexEntry
+	score     int
+	matches   []int // byte offsets of matched characters in the name
+	installed bool
+}
+
+// searchPackages ranks index entries against query. The CLI find command and
+// the TUI both go through here so they always agree on what matches.
+//
+// By default the name and tags are matched fuzzily and the description by
+// substring, since fuzzy matching over prose matches nearly everything.
+// Results are ordered by score and then by name.
+func searchPackages(packages map[string]*indexEntry, query string, opts searchOptions, installed map[string]bool) ([]searchResult, error) {
+	var re *regexp.Regexp
+	if opts.regex {
+		var err error
+		re, err = regexp.Compile("(?i)" + query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+	}
+	var results []searchResult
+	for _, e := range packages {
+		if opts.installed && !installed[e.Name] {
+			continue
+		}
+		r := searchResult{entry: e, installed: installed[e.Name]}
+		var ok bool
+		switch {
+		case query == "":
+			ok = true
+		case opts.exact:
+			ok = strings.EqualFold(e.Name, query)
+			if ok {
+				r.matches = span(0, len(e.Name))
+			}
+		case opts.regex:
+			ok = r.matchRegexp(re)
+		default:
+			ok = r.matchFuzzy(query)
+		}
+		if ok {
+			results = append(results, r)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].entry.Name < results[j].entry.Name
+	})
+	return results, nil
+}
+
+// withInstalled adds the installed packages the index does not list, such
+// as ones installed with --from, to packages, and returns the set of
+// installed names alongside.
+func withInstalled(packages map[string]*indexEntry) (map[string]*indexEntry, map[string]bool) {
+	pkgs, err := listInstalled()
+	if err != nil {
+		slog.Warn("Could not list installed packages", "err", err)
+	}
+	installed := make(map[string]bool)
+	all := make(map[string]*indexEntry, len(packages))
+	for name, e := range packages {
+		all[name] = e
+	}
+	for _, p := range pkgs {
+		installed[p.Name] = true
+		if _, ok := all[p.Name]; ok {
+			continue
+		}
+		e := &indexEntry{Name: p.Name, URL: p.URL}
+		if mf, err := loadManifest(packageDir(p.Name)); err == nil && mf != nil {
+			e.Description = mf.Description
+		}
+		all[p.Name] = e
+	}
+	return all, installed
+}
+
+func (r *searchResult) matchFuzzy(query string) bool {
+	e := r.entry
+	if m := fuzzy.Find(query, []string{e.Name}); len(m) > 0 {
+		r.score = nameMatchBonus + m[0].Score
+		r.matches = m[0].MatchedIndexes
+		return true
+	}
+	if m := fuzzy.Find(query, e.Tags); len(m) > 0 {
+		r.score = tagMatchBonus + m[0].Score
+		return true
+	}
+	if strings.Contains(strings.ToLower(e.Description), strings.ToLower(query)) {
+		r.score = descMatchBonus
+		return true
+	}
+	return false
+}
+
+func (r *searchResult) matchRegexp(re *regexp.Regexp) bool {
+	e := r.entry
+	if locs := re.FindAllStringIndex(e.Name, -1); len(locs) > 0 {
+		r.score = nameMatchBonus
+		for _, loc := range locs {
+			r.matches = append(r.matches, span(loc[0], loc[1])...)
+		}
+		return true
+	}
+	for _, tag := range e.Tags {
+		if re.MatchString(tag) {
+			r.score = tagMatchBonus
+			return true
+		}
+	}
+	if re.MatchString(e.Description) {
+		r.score = descMatchBonus
+		return true
+	}
+	return false
+}
+
+func span(from, to int) []int {
+	idx := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// highlightedName renders the package name with the matched characters
+// emphasised.
+func (r searchResult) highlightedName() string {
+	if len(r.matches) == 0 {
+		return r.entry.Name
+	}
+	matched := make(map[int]bool, len(r.matches))
+	for _, i := range r.matches {
+		matched[i] = true
+	}
+	var b strings.Builder
+	for i, c := range r.entry.Name {
+		if matched[i] {
+			b.WriteString(matchStyle.Render(string(c)))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (r searchResult) summary() string {
+	desc := r.entry.Description
+	if desc == "" {
+		desc = r.entry.URL
+	}
+	if len(r.entry.Tags) > 0 {
+		desc += " [" + strings.Join(r.entry.Tags, ", ") + "]"
+	}
+	if r.installed {
+		desc += " (installed)"
+	}
+	return desc
+}
+
+// resultItem is a search result in the TUI list.
+type resultItem struct {
+	searchResult
+}
+
+func (i resultItem) Title() string       { return i.highlightedName() }
+func (i resultItem) Description() string { return i.summary() }
+func (i resultItem) FilterValue() string { return i.entry.Name }
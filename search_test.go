@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFindIncludesUnlistedInstalledPackages(t *testing.T) {
+	useTestConfig(t)
+	for _, name := range []string{"listed", "local"} {
+		if err := os.MkdirAll(packageDir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := map[string]*indexEntry{
+		"listed": {Name: "listed", URL: "https://example.org/listed"},
+		"other":  {Name: "other", URL: "https://example.org/other"},
+	}
+	packages, installed := withInstalled(index)
+	results, err := searchPackages(packages, "", searchOptions{installed: true}, installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range results {
+		names = append(names, r.entry.Name)
+	}
+	if len(names) != 2 || names[0] != "listed" || names[1] != "local" {
+		t.Fatalf("find --installed = %v, want [listed local]", names)
+	}
+	if len(index) != 2 {
+		t.Error("withInstalled changed the index")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const submitIssueURL = "https://github.com/LegendaryOS/lcr/issues/new"
+
+// submission is what `lcr submit` gathers about a package.
+type submission struct {
+	entry    indexEntry
+	manifest *manifest
+	warnings []lintFinding
+}
+
+// prepareSubmission lints the package at target and builds its index entry.
+// The URL is target itself, or the origin remote of a local checkout unless
+// url overrides it.
+func prepareSubmission(target, url, tags string) (*submission, error) {
+	dir, cleanup, err := packageCheckout(target)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	s := &submission{}
+	var errs []string
+	for _, f := range lintPackage(dir) {
+		if f.Severity == "error" {
+			errs = append(errs, fmt.Sprintf("%s: %s", f.location(), f.Message))
+		} else {
+			s.warnings = append(s.warnings, f)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("lint found errors, fix them first:\n%s", strings.Join(errs, "\n"))
+	}
+
+	if url == "" {
+		if isRepoURL(target) {
+			url = target
+		} else if url, err = originURL(dir); err != nil {
+			return nil, fmt.Errorf("%s has no origin remote to submit; push it and pass --url: %w", target, err)
+		}
+	}
+	if err := validateRepoURL(url); err != nil {
+		return nil, err
+	}
+	if s.manifest, err = loadManifest(dir); err != nil {
+		return nil, err
+	}
+	s.entry = indexEntry{URL: url}
+	if s.manifest != nil {
+		s.entry.Name = s.manifest.Name
+		s.entry.Description = s.manifest.Description
+	}
+	if s.entry.Name == "" && isRepoURL(target) {
+		s.entry.Name = strings.TrimSuffix(path.Base(strings.TrimSuffix(target, "/")), ".git")
+	} else if s.entry.Name == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		s.entry.Name = filepath.Base(abs)
+	}
+	if err := validatePackageName(s.entry.Name); err != nil {
+		return nil, err
+	}
+	if tags != "" {
+		s.entry.set("tags", tags)
+	}
+	return s, nil
+}
+
+// indexLines renders the entry as it would appear in repo-list.lcr.
+func (s *submission) indexLines() string {
+	idx := &indexFile{entries: []*indexFileEntry{{indexEntry: s.entry}}}
+	return idx.format()
+}
+
+// writeIssue writes a pre-filled issue body for the submission.
+func (s *submission) writeIssue(w io.Writer) {
+	fmt.Fprintf(w, "## Package submission: %s\n\n", s.entry.Name)
+	fmt.Fprintf(w, "Please add this entry to library/repo-list.lcr:\n\n```\n%s```\n\n", s.indexLines())
+	fmt.Fprintf(w, "| | |\n|---|---|\n")
+	fmt.Fprintf(w, "| Repository | %s |\n", s.entry.URL)
+	if mf := s.manifest; mf != nil {
+		for _, row := range []struct{ label, value string }{
+			{"Version", mf.Version},
+			{"Depends", strings.Join(mf.Depends, ", ")},
+			{"Requires", strings.Join(mf.Requires, ", ")},
+			{"Architectures", strings.Join(mf.Arch, ", ")},
+		} {
+			if row.value != "" {
+				fmt.Fprintf(w, "| %s | %s |\n", row.label, row.value)
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "| Manifest | none |\n")
+	}
+	fmt.Fprintf(w, "\n`lcr lint` passed")
+	if len(s.warnings) == 0 {
+		fmt.Fprintf(w, " without warnings.\n")
+		return
+	}
+	fmt.Fprintf(w, " with %d warnings:\n\n", len(s.warnings))
+	for _, f := range s.warnings {
+		fmt.Fprintf(w, "- %s: %s\n", f.location(), f.Message)
+	}
+}
+
+// runSubmitCommand implements `lcr submit [--url url] [--tags tags] [-o file] [path|url]`.
+func runSubmitCommand(args []string) error {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	url := fs.String("url", "", "Repository URL to submit, by default the origin remote")
+	tags := fs.String("tags", "", "Comma-separated tags for the index entry")
+	output := fs.String("o", "", "Write the issue body to this file instead of stdout")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: lcr submit [--url url] [--tags tags] [-o file] [path|url]")
+	}
+	target := "."
+	if fs.NArg() == 1 {
+		target = fs.Arg(0)
+	}
+	s, err := prepareSubmission(target, *url, *tags)
+	if err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	s.writeIssue(w)
+	if *output != "" {
+		fmt.Printf("Wrote the submission for %s to %s.\n", s.entry.Name, *output)
+	}
+	fmt.Fprintf(os.Stderr, "Open an issue at %s and paste the submission into it.\n", submitIssueURL)
+	return nil
+}
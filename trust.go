@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+var errScriptRejected = errors.New("script was not approved")
+
+// approvedScript is a package script the user has reviewed. The content is
+// kept so a later change can be shown as a diff against it.
+type approvedScript struct {
+	SHA256  string `json:"sha256"`
+	Content string `json:"content"`
+}
+
+// trustDB maps package name and script name to the approved version.
+type trustDB map[string]map[string]approvedScript
+
+func trustDBPath() string {
+	return filepath.Join(cfg.StateDir, "trusted-scripts.json")
+}
+
+func loadTrustDB() (trustDB, error) {
+	db := make(trustDB)
+	data, err := os.ReadFile(trustDBPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", trustDBPath(), err)
+	}
+	return db, nil
+}
+
+func (db trustDB) save() error {
+	path := trustDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// scriptReview is a script waiting for approval. previous is the approved
+// content it replaces, empty on first install.
+type scriptReview struct {
+	pakiet   string
+	script   string
+	url      string
+	content  string
+	previous string
+}
+
+// trustedSource tells whether url is one of cfg.TrustedSources or lies
+// below one of them. A prefix only matches whole path components, so
+// https://github.com/org does not trust https://github.com/org-evil.
+func trustedSource(url string) bool {
+	for _, prefix := range cfg.TrustedSources {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix != "" && (url == prefix || strings.HasPrefix(url, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScript makes sure a package script may run under cfg.ScriptPolicy.
+// In review mode a script that is new or changed since it was last approved
+// is shown to the user, and the answer is remembered.
+func (m *model) checkScript(pakiet, script string) error {
+	data, err := os.ReadFile(script)
+	if err != nil {
+		return err
+	}
+	return m.checkApproved(pakiet, filepath.Base(script), data)
+}
+
+// installPlanName is what the [[install]] entries of a manifest are
+// reviewed and approved as.
+const installPlanName = "install plan"
+
+// checkInstallPlan reviews the [[install]] entries of a manifest like a
+// script, since they write to the system just as freely.
+func (m *model) checkInstallPlan(pakiet string, mf *manifest) error {
+	var b strings.Builder
+	for _, e := range mf.Install {
+		switch {
+		case e.Link != "":
+			fmt.Fprintf(&b, "link %s -> %s\n", e.target(), e.Link)
+		case e.Mode != "":
+			fmt.Fprintf(&b, "copy %s -> %s mode %s\n", e.Source, e.target(), e.Mode)
+		default:
+			fmt.Fprintf(&b, "copy %s -> %s\n", e.Source, e.target())
+		}
+	}
+	return m.checkApproved(pakiet, installPlanName, []byte(b.String()))
+}
+
+// checkApproved asks for approval of data, the current content of the
+// package's script name, unless the policy or an earlier approval allows it.
+func (m *model) checkApproved(pakiet, name string, data []byte) error {
+	switch cfg.ScriptPolicy {
+	case "trust":
+		return nil
+	case "review":
+	default:
+		return fmt.Errorf("unknown script_policy %q", cfg.ScriptPolicy)
+	}
+	url, _ := originURL(packageDir(pakiet))
+	if trustedSource(url) {
+		slog.Debug("Script from trusted source", "package", pakiet, "url", url)
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	db, err := loadTrustDB()
+	if err != nil {
+		return err
+	}
+	prev := db[pakiet][name]
+	if prev.SHA256 == hash {
+		return nil
+	}
+	r := scriptReview{pakiet: pakiet, script: name, url: url, content: string(data), previous: prev.Content}
+	approve := m.reviewer
+	if approve == nil {
+		if !stdinIsTerminal() {
+			return fmt.Errorf("%w: %s of %s needs review; run lcr from a terminal or add its source to trusted_sources", errScriptRejected, name, pakiet)
+		}
+		approve = reviewOnTerminal
+	}
+	if !approve(r) {
+		slog.Warn("Script rejected", "package", pakiet, "script", name, "sha256", hash)
+		return fmt.Errorf("%s of %s: %w", name, pakiet, errScriptRejected)
+	}
+	if db[pakiet] == nil {
+		db[pakiet] = make(map[string]approvedScript)
+	}
+	db[pakiet][name] = approvedScript{SHA256: hash, Content: string(data)}
+	slog.Info("Script approved", "package", pakiet, "script", name, "sha256", hash)
+	return db.save()
+}
+
+func reviewOnTerminal(r scriptReview) bool {
+	fmt.Print(r.render())
+	return askYesNo(fmt.Sprintf("Run %s?", r.script))
+}
+
+func (m *model) showReview(msg scriptReviewMsg) (tea.Model, tea.Cmd) {
+	m.review = &msg
+	m.reviewView.SetContent(msg.review.render())
+	m.reviewView.GotoTop()
+	m.state = stateReview
+	return m, nil
+}
+
+// updateReview passes the answer back to the waiting operation and resumes
+// following its events.
+func (m *model) updateReview(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "ctrl+c":
+			// Reject so the operation cleans up, as it would for n.
+			slog.Warn("User aborted a script review", "package", m.review.review.pakiet)
+			m.review.reply <- false
+			m.review = nil
+			// View runs once more before quitting and needs a review to show.
+			m.state = stateExec
+			return m, tea.Quit
+		case "y", "n", "esc":
+			approved := key.String() == "y"
+			slog.Debug("Script review answered", "package", m.review.review.pakiet, "approved", approved)
+			m.review.reply <- approved
+			m.review = nil
+			m.state = stateExec
+			return m, tea.Batch(m.spinner.Tick, waitForEvent(m.events))
+		}
+	}
+	var cmd tea.Cmd
+	m.reviewView, cmd = m.reviewView.Update(msg)
+	return m, cmd
+}
+
+// render shows a new script in full and a changed one as a diff against the
+// approved version.
+func (r scriptReview) render() string {
+	var b strings.Builder
+	if r.previous == "" {
+		fmt.Fprintf(&b, "%s of %s (%s) has not been reviewed yet:\n\n", r.script, r.pakiet, r.url)
+		for i, line := range strings.Split(strings.TrimRight(r.content, "\n"), "\n") {
+			fmt.Fprintf(&b, "%4d  %s\n", i+1, highlightShell(line))
+		}
+	} else {
+		fmt.Fprintf(&b, "%s of %s (%s) changed since it was approved:\n\n", r.script, r.pakiet, r.url)
+		for _, d := range diff.Do(r.previous, r.content) {
+			for _, line := range strings.Split(strings.TrimSuffix(d.Text, "\n"), "\n") {
+				switch d.Type {
+				case diffmatchpatch.DiffInsert:
+					b.WriteString(diffAddStyle.Render("+ "+line) + "\n")
+				case diffmatchpatch.DiffDelete:
+					b.WriteString(diffDelStyle.Render("- "+line) + "\n")
+				default:
+					b.WriteString("  " + highlightShell(line) + "\n")
+				}
+			}
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+var shellKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"for": true, "while": true, "until": true, "do": true, "done": true,
+	"case": true, "esac": true, "in": true, "function": true,
+	"return": true, "exit": true, "set": true, "export": true,
+}
+
+// highlightShell colors one line of shell: keywords, variables, quoted
+// strings and comments. It is a tokenizer, not a parser, which is enough
+// for reading a script.
+func highlightShell(line string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			b.WriteString(shellCommentStyle.Render(line[i:]))
+			return b.String()
+		case c == '\'' || c == '"':
+			j := strings.IndexByte(line[i+1:], c)
+			if j < 0 {
+				j = len(line) - i - 2
+			}
+			b.WriteString(shellStringStyle.Render(line[i : i+j+2]))
+			i += j + 2
+		case c == '$':
+			j := i + 1
+			if j < len(line) && line[j] == '{' {
+				if k := strings.IndexByte(line[j:], '}'); k >= 0 {
+					j += k + 1
+				}
+			} else {
+				for j < len(line) && isShellWordChar(line[j]) {
+					j++
+				}
+			}
+			b.WriteString(shellVarStyle.Render(line[i:j]))
+			i = j
+		case isShellWordChar(c):
+			j := i
+			for j < len(line) && isShellWordChar(line[j]) {
+				j++
+			}
+			if word := line[i:j]; shellKeywords[word] {
+				b.WriteString(shellKeywordStyle.Render(word))
+			} else {
+				b.WriteString(word)
+			}
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isShellWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
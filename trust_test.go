@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestReviewCtrlCRejectsAndRenders(t *testing.T) {
+	m := initialModel()
+	reply := make(chan bool, 1)
+	m.showReview(scriptReviewMsg{review: scriptReview{pakiet: "pkg", script: "unpack.sh", content: "true\n"}, reply: reply})
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	if cmd == nil {
+		t.Fatal("ctrl+c did not quit")
+	}
+	if approved := <-reply; approved {
+		t.Error("ctrl+c approved the script")
+	}
+	// Bubble Tea renders once more before it handles tea.Quit.
+	m.View()
+}
+
+func TestTrustedSource(t *testing.T) {
+	saved := cfg.TrustedSources
+	t.Cleanup(func() { cfg.TrustedSources = saved })
+	cfg.TrustedSources = []string{"https://github.com/LegendaryOS", "https://example.org/pkgs/", ""}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://github.com/LegendaryOS", true},
+		{"https://github.com/LegendaryOS/", true},
+		{"https://github.com/LegendaryOS/vira", true},
+		{"https://github.com/LegendaryOS-evil/pkg", false},
+		{"https://github.com/LegendaryOSx", false},
+		{"https://example.org/pkgs", true},
+		{"https://example.org/pkgs/a.git", true},
+		{"https://example.org/pkgsmore/a.git", false},
+		{"https://github.com/other/pkg", false},
+	}
+	for _, tt := range tests {
+		if got := trustedSource(tt.url); got != tt.want {
+			t.Errorf("trustedSource(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
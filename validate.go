@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const maxPackageNameLen = 64
+
+// A package name becomes a directory below the install root, so it may not
+// contain a path separator or start with a dot.
+var packageNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// scpLikeRe matches the user@host:path form git accepts for ssh.
+var scpLikeRe = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^\s]+$`)
+
+func validatePackageName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("package name is empty")
+	case len(name) > maxPackageNameLen:
+		return fmt.Errorf("package name %.20q... is longer than %d characters", name, maxPackageNameLen)
+	case !packageNameRe.MatchString(name):
+		return fmt.Errorf("invalid package name %q: use letters, digits, '.', '_', '+' and '-', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// validateRepoURL accepts https and ssh repositories, and local ones only
+// when allow_file_urls is set.
+func validateRepoURL(raw string) error {
+	if strings.ContainsFunc(raw, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return fmt.Errorf("repository URL %q contains whitespace or control characters", raw)
+	}
+	scheme := "file"
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("invalid repository URL %q: %w", raw, err)
+		}
+		scheme = u.Scheme
+		if scheme != "file" && u.Host == "" {
+			return fmt.Errorf("repository URL %q has no host", raw)
+		}
+	} else if scpLikeRe.MatchString(raw) {
+		scheme = "ssh"
+	}
+	switch scheme {
+	case "https", "ssh":
+		if scheme == "ssh" && isArchiveURL(raw) {
+			return fmt.Errorf("archive %q must be downloaded over https", raw)
+		}
+		return nil
+	case "file":
+		if cfg.AllowFileURLs {
+			return nil
+		}
+		return fmt.Errorf("local repository %q is not allowed; set allow_file_urls to use it", raw)
+	}
+	return fmt.Errorf("repository URL %q uses unsupported scheme %s; use https or ssh", raw, scheme)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidatePackageName(t *testing.T) {
+	valid := []string{"vira", "a", "0ad", "lib.foo", "gtk+", "my_pkg-2", strings.Repeat("a", maxPackageNameLen)}
+	for _, name := range valid {
+		if err := validatePackageName(name); err != nil {
+			t.Errorf("validatePackageName(%q) = %v, want nil", name, err)
+		}
+	}
+	invalid := []string{"", ".", "..", ".hidden", "-flag", "_x", "a/b", "../etc", "a b", "a\x00b", "ü", strings.Repeat("a", maxPackageNameLen+1)}
+	for _, name := range invalid {
+		if err := validatePackageName(name); err == nil {
+			t.Errorf("validatePackageName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+func TestValidateRepoURL(t *testing.T) {
+	saved := cfg.AllowFileURLs
+	t.Cleanup(func() { cfg.AllowFileURLs = saved })
+	tests := []struct {
+		url        string
+		ok, okFile bool
+	}{
+		{"https://github.com/LegendaryOS/vira", true, true},
+		{"ssh://git@github.com/LegendaryOS/vira.git", true, true},
+		{"git@github.com:LegendaryOS/vira.git", true, true},
+		{"https://example.org/vira-0.3.0.tar.gz", true, true},
+		{"ssh://git@example.org/vira-0.3.0.tar.gz", false, false},
+		{"http://github.com/LegendaryOS/vira", false, false},
+		{"git://github.com/LegendaryOS/vira", false, false},
+		{"ext::sh -c touch% /tmp/pwned", false, false},
+		{"https:///no-host", false, false},
+		{"https://github.com/a b", false, false},
+		{"https://github.com/a\nb", false, false},
+		{"file:///srv/vira", false, true},
+		{"/srv/vira", false, true},
+		{"../vira", false, true},
+	}
+	for _, tt := range tests {
+		for _, allow := range []bool{false, true} {
+			cfg.AllowFileURLs = allow
+			want := tt.ok
+			if allow {
+				want = tt.okFile
+			}
+			if err := validateRepoURL(tt.url); (err == nil) != want {
+				t.Errorf("validateRepoURL(%q) with allow_file_urls=%v = %v, want ok=%v", tt.url, allow, err, want)
+			}
+		}
+	}
+}
+
+func TestParseRepoListSkipsInvalidEntries(t *testing.T) {
+	saved := cfg.AllowFileURLs
+	t.Cleanup(func() { cfg.AllowFileURLs = saved })
+	cfg.AllowFileURLs = false
+	list := `vira -> https://github.com/LegendaryOS/vira
+    description: A good one
+../evil -> https://github.com/x/evil
+    description: skipped with its entry
+local -> /srv/local
+plain -> http://github.com/x/plain
+vira2 -> https://github.com/LegendaryOS/vira2
+`
+	path := filepath.Join(t.TempDir(), "repo-list.lcr")
+	if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := parseRepoList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs["vira"] == nil || pkgs["vira2"] == nil {
+		t.Fatalf("parseRepoList kept %v, want vira and vira2", pkgs)
+	}
+	if pkgs["vira"].Description != "A good one" {
+		t.Errorf("vira description = %q", pkgs["vira"].Description)
+	}
+}